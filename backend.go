@@ -2,8 +2,10 @@ package secrets
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -19,94 +21,202 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 }
 
 // shellBackend defines an object that
-// extends the Vault backend and stores the
-// target API's client.
+// extends the Vault backend and stores a
+// client for each configured connection.
 type shellBackend struct {
 	*framework.Backend
-	lock   sync.RWMutex
-	client *shellClient
+	lock    sync.RWMutex
+	clients map[string]*shellClient
+
+	// limitersLock guards the limiters of each connection,
+	// which outlive the connection's clients
+	limitersLock sync.Mutex
+	limiters     map[string]*hostLimiter
+
+	// rotationLock serializes password rotations
+	// so a password is never set twice at once
+	rotationLock sync.Mutex
+
+	// libraryLock serializes check-outs and check-ins
+	// so an account is never handed out twice
+	libraryLock sync.Mutex
+
+	// tidyLock prevents tidy runs from overlapping
+	// and guards the time of the last run
+	tidyLock sync.Mutex
+	lastTidy time.Time
+
+	// revocationLock prevents retries of pending
+	// revocations from overlapping
+	revocationLock sync.Mutex
+
+	// historyLock serializes updates to role histories
+	// so concurrent events are not lost
+	historyLock sync.Mutex
 }
 
 // backend defines the target API backend
 // for Vault. It must include each path
 // and the secrets it will store.
 func backend() *shellBackend {
-	var b = shellBackend{}
+	var b = shellBackend{
+		clients:  make(map[string]*shellClient),
+		limiters: make(map[string]*hostLimiter),
+	}
 
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
 		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				publicKeyPath,
+			},
 			LocalStorage: []string{
 				// WAL stands for Write-Ahead-Log, which is used for Vault replication
 				framework.WALPrefix,
 			},
 			SealWrapStorage: []string{
+				// root rotation WAL entries hold the new admin password
+				framework.WALPrefix,
 				configStoragePath,
+				connectionStoragePath,
+				caStoragePath,
 				hostRoleStoragePath,
+				staticRoleStoragePath,
+				libraryStoragePath,
+				libraryAccountStoragePath,
 			},
 		},
 		Paths: framework.PathAppend(
+			// config/ca, config/rotate-root and config/tidy are
+			// matched before the named connections at config/<name>
+			pathRotateRoot(&b),
+			pathConfigCA(&b),
+			pathTidy(&b),
 			pathConfig(&b),
+			pathRevocations(&b),
+			pathKnownHosts(&b),
+			pathHistory(&b),
 			pathRole(&b),
 			pathCredentials(&b),
+			pathSign(&b),
+			pathStaticRole(&b),
+			pathStaticCredentials(&b),
+			pathLibraryCheckOut(&b),
+			pathLibrary(&b),
 		),
 		Secrets: []*framework.Secret{
 			b.credObject(),
+			b.libraryAccount(),
 		},
-		BackendType: logical.TypeLogical,
-		Invalidate:  b.invalidate,
+		BackendType:    logical.TypeLogical,
+		Invalidate:     b.invalidate,
+		InitializeFunc: b.initialize,
+		PeriodicFunc:   b.periodicFunc,
+		Clean:          b.clean,
+
+		WALRollback:       b.walRollback,
+		WALRollbackMinAge: walRollbackMinAge,
 	}
 	return &b
 }
 
+// initialize migrates storage written by earlier
+// versions of the backend
+func (b *shellBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	return migrateConfig(ctx, req.Storage)
+}
+
+// clean closes pooled connections when the
+// backend is unmounted or reloaded
+func (b *shellBackend) clean(ctx context.Context) {
+	b.reset()
+}
+
 // reset clears any client configuration for a new
-// backend to be configured
+// backend to be configured and closes pooled connections
 func (b *shellBackend) reset() {
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	b.client = nil
+	for _, client := range b.clients {
+		client.close()
+	}
+	b.clients = make(map[string]*shellClient)
+}
+
+// resetClient clears the client of a single connection
+// so it is recreated from its new configuration
+func (b *shellBackend) resetClient(connection string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if client, ok := b.clients[connection]; ok {
+		client.close()
+	}
+	delete(b.clients, connection)
+}
+
+// closeIdleConnections closes pooled connections
+// that have not been used recently
+func (b *shellBackend) closeIdleConnections() {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, client := range b.clients {
+		client.pool.closeIdle()
+	}
 }
 
 // invalidate clears an existing client configuration in
 // the backend
 func (b *shellBackend) invalidate(ctx context.Context, key string) {
-	if key == "config" {
+	if connection, ok := connectionFromStorageKey(key); ok {
+		b.resetClient(connection)
+	}
+
+	// close connections verified against a host key that changed
+	if strings.HasPrefix(key, knownHostsStoragePath) {
 		b.reset()
 	}
 }
 
 // getClient locks the backend as it configures and creates a
-// a new client for the target API
-func (b *shellBackend) getClient(ctx context.Context, s logical.Storage) (*shellClient, error) {
+// a new client for the connection's target
+func (b *shellBackend) getClient(ctx context.Context, s logical.Storage, connection string) (*shellClient, error) {
+	if connection == "" {
+		connection = defaultConnectionName
+	}
+
 	b.lock.RLock()
 	unlockFunc := b.lock.RUnlock
 	defer func() { unlockFunc() }()
 
-	if b.client != nil {
-		return b.client, nil
+	if client, ok := b.clients[connection]; ok {
+		return client, nil
 	}
 
 	b.lock.RUnlock()
 	b.lock.Lock()
 	unlockFunc = b.lock.Unlock
 
-	config, err := getConfig(ctx, s)
+	if client, ok := b.clients[connection]; ok {
+		return client, nil
+	}
+
+	config, err := getConfig(ctx, s, connection)
 	if err != nil {
 		return nil, err
 	}
 
-	if b.client == nil {
-		if config == nil {
-			config = new(shellConfig)
-		}
+	if config == nil {
+		return nil, fmt.Errorf("connection %q is not configured", connection)
 	}
 
-	b.client, err = newClient(config)
+	client, err := newClient(config, s)
 	if err != nil {
 		return nil, err
 	}
+	client.limiter = b.connectionLimiter(connection, config)
+	b.clients[connection] = client
 
-	return b.client, nil
+	return client, nil
 }
 
 // backendHelp should contain help information for the backend
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// getTestBackend returns a backend set up with in-memory storage.
+func getTestBackend(t *testing.T) (*shellBackend, logical.Storage) {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	config.Logger = hclog.NewNullLogger()
+
+	b, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*shellBackend), config.StorageView
+}
+
+// testContext returns a context that is cancelled when the test ends.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// testRequest sends a request to the backend and fails the
+// test if it returns an error or an error response.
+func testRequest(t *testing.T, b *shellBackend, s logical.Storage, operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: operation,
+		Path:      path,
+		Data:      data,
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("%s %s: err: %v resp: %#v", operation, path, err, resp)
+	}
+	return resp
+}
+
+// testConfigure writes the default connection for the server.
+func testConfigure(t *testing.T, b *shellBackend, s logical.Storage, server *testSSHServer) {
+	t.Helper()
+
+	testRequest(t, b, s, logical.CreateOperation, configPath, map[string]interface{}{
+		"username": "admin",
+		"password": "admin-password",
+		"url":      server.addr,
+	})
+}
+
+// testRevoke revokes the lease in resp and returns the backend's response.
+func testRevoke(t *testing.T, b *shellBackend, s logical.Storage, resp *logical.Response) (*logical.Response, error) {
+	t.Helper()
+
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    resp.Secret,
+		Storage:   s,
+	})
+}
+
+// testRollback rolls back every WAL entry regardless of its age.
+func testRollback(t *testing.T, b *shellBackend, s logical.Storage) {
+	t.Helper()
+
+	testRequest(t, b, s, logical.RollbackOperation, "", map[string]interface{}{
+		"immediate": true,
+	})
+}
+
+// testRenew renews the lease in resp by increment, as if
+// it had been issued at issueTime.
+func testRenew(t *testing.T, b *shellBackend, s logical.Storage, resp *logical.Response, issueTime time.Time, increment time.Duration) *logical.Response {
+	t.Helper()
+
+	secret := *resp.Secret
+	secret.IssueTime = issueTime
+	secret.Increment = increment
+
+	renewed, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Secret:    &secret,
+		Storage:   s,
+	})
+	if err != nil || (renewed != nil && renewed.IsError()) {
+		t.Fatalf("renew: err: %v resp: %#v", err, renewed)
+	}
+	return renewed
+}
@@ -1,19 +1,48 @@
 package secrets
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultSSHPort    = "22"
+	defaultSSHTimeout = 30 * time.Second
 )
 
+// validUsername matches the portable subset of Linux account names
+// accepted by useradd. Every username is checked against it before
+// it is placed in a remote command.
+var validUsername = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
 // shellClient creates an object storing
-// the client.
+// the SSH configuration used to log in
+// to target hosts.
 type shellClient struct {
-	// add your client here
+	sshConfig *ssh.ClientConfig
+	url       string
+	pool      *connPool
+	limiter   *hostLimiter
+
+	// storage holds the known-hosts store that
+	// host keys are verified against
+	storage       logical.Storage
+	hostKeyPolicy string
 }
 
-// newClient creates a new client to access your endpoint
-// and exposes it for any secrets or roles to use.
-// TODO: Implement code to call your client.
-func newClient(config *shellConfig) (*shellClient, error) {
+// newClient creates a new SSH client from the backend
+// configuration and exposes it for any secrets or roles to use.
+// Host keys are verified against the known-hosts store in s.
+func newClient(config *shellConfig, s logical.Storage) (*shellClient, error) {
 	if config == nil {
 		return nil, errors.New("client configuration was nil")
 	}
@@ -30,5 +59,333 @@ func newClient(config *shellConfig) (*shellClient, error) {
 		return nil, errors.New("client URL was not defined")
 	}
 
-	return &shellClient{}, nil
+	hostKeyPolicy := config.HostKeyPolicy
+	if hostKeyPolicy == "" {
+		hostKeyPolicy = defaultHostKeyPolicy
+	}
+
+	password := config.Password
+	return &shellClient{
+		sshConfig: &ssh.ClientConfig{
+			User: config.Username,
+			Auth: []ssh.AuthMethod{
+				ssh.Password(password),
+				ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+					answers := make([]string, len(questions))
+					for i := range questions {
+						answers[i] = password
+					}
+					return answers, nil
+				}),
+			},
+			Timeout: defaultSSHTimeout,
+		},
+		url:           config.URL,
+		pool:          newConnPool(),
+		limiter:       newHostLimiter(config.MaxConcurrentOperations, config.RequestsPerSecond, config.ConnectionMaxConcurrentOperations, config.ConnectionRequestsPerSecond),
+		storage:       s,
+		hostKeyPolicy: hostKeyPolicy,
+	}, nil
+}
+
+// withHostKeyPolicy returns a copy of the client that verifies host
+// keys with the given policy. The copy shares the client's pooled
+// connections and limits. An empty policy keeps the client's own.
+func (c *shellClient) withHostKeyPolicy(policy string) *shellClient {
+	if policy == "" || policy == c.hostKeyPolicy {
+		return c
+	}
+	copied := *c
+	copied.hostKeyPolicy = policy
+	return &copied
+}
+
+// close closes the client's pooled connections.
+func (c *shellClient) close() {
+	c.pool.close()
+}
+
+// hostAddress converts a host name, host:port pair or ssh:// URL
+// into an address that can be dialed.
+func hostAddress(host string) string {
+	host = strings.TrimPrefix(host, "ssh://")
+	host = strings.TrimSuffix(host, "/")
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultSSHPort)
+}
+
+// address returns the address to dial for the host. If host
+// is empty, the client connects to the configured URL.
+func (c *shellClient) address(host string) string {
+	if host == "" {
+		host = c.url
+	}
+	return hostAddress(host)
+}
+
+// dial opens a new SSH connection to the address.
+func (c *shellClient) dial(ctx context.Context, addr string) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: c.sshConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sshConfig := *c.sshConfig
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return checkHostKey(ctx, c.storage, c.hostKeyPolicy, hostname, key)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &sshConfig)
+	if err != nil {
+		conn.Close()
+		var hostKeyErr *hostKeyError
+		if errors.As(err, &hostKeyErr) {
+			return nil, hostKeyErr
+		}
+		return nil, fmt.Errorf("error logging in to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// run executes a command on the host and returns its standard output.
+// Anything passed as stdin is written to the command's standard input,
+// which keeps secrets out of the remote process list. Commands share
+// pooled connections to the host, each in a session of its own.
+func (c *shellClient) run(ctx context.Context, host, command, stdin string) (out string, err error) {
+	addr := c.address(host)
+	defer func(start time.Time) {
+		emitCommandMetrics(addr, start, err)
+	}(time.Now())
+
+	key := c.hostKeyPolicy + "/" + addr
+	dial := func(ctx context.Context) (*ssh.Client, error) {
+		return c.dial(ctx, addr)
+	}
+
+	conn, err := c.pool.get(ctx, key, dial)
+	if err != nil {
+		return "", err
+	}
+
+	session, err := newSession(ctx, conn)
+	if err != nil {
+		// The pooled connection may have gone away since its
+		// health check, so retry once on a new connection
+		c.pool.put(key, conn, true)
+		if conn, err = c.pool.get(ctx, key, dial); err != nil {
+			return "", err
+		}
+		if session, err = newSession(ctx, conn); err != nil {
+			c.pool.put(key, conn, true)
+			return "", fmt.Errorf("error opening session: %w", err)
+		}
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin = strings.NewReader(stdin)
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	select {
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		c.pool.put(key, conn, true)
+		return "", ctx.Err()
+	case err := <-done:
+		// A non-zero exit status leaves the connection usable
+		var exitErr *ssh.ExitError
+		c.pool.put(key, conn, err != nil && !errors.As(err, &exitErr))
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("remote command failed: %w: %s", err, msg)
+			}
+			return "", fmt.Errorf("remote command failed: %w", err)
+		}
+	}
+
+	return stdout.String(), nil
+}
+
+// newSession opens a session on the connection. It gives up if the
+// server does not answer within connectionRequestTimeout or ctx is done,
+// after which the caller must close the connection to end the request.
+func newSession(ctx context.Context, conn *ssh.Client) (*ssh.Session, error) {
+	type result struct {
+		session *ssh.Session
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		session, err := conn.NewSession()
+		done <- result{session, err}
+	}()
+
+	timer := time.NewTimer(connectionRequestTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case r := <-done:
+		return r.session, r.err
+	case <-timer.C:
+		err = errors.New("timed out opening session")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// Close a session the server opens after all
+	go func() {
+		if r := <-done; r.session != nil {
+			r.session.Close()
+		}
+	}()
+	return nil, err
+}
+
+// verify logs in to the configured URL and runs a command that
+// changes nothing, to check the connection's credentials.
+func (c *shellClient) verify(ctx context.Context) error {
+	_, err := c.run(ctx, "", "true", "")
+	return err
+}
+
+// setPassword sets the password of an existing account on the host.
+func (c *shellClient) setPassword(ctx context.Context, host, username, password string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+
+	_, err := c.run(ctx, host, "chpasswd", username+":"+password+"\n")
+	return err
+}
+
+// createUser adds a new local account with a home directory to the host.
+// If password is empty, the account is created with a locked password.
+// If expiration is set, the account expires at the end of that day.
+func (c *shellClient) createUser(ctx context.Context, host, username, password string, expiration time.Time) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+
+	command := "useradd -m "
+	if !expiration.IsZero() {
+		command += "-e " + accountExpiryDate(expiration) + " "
+	}
+	command += username
+
+	if password == "" {
+		_, err := c.run(ctx, host, command, "")
+		return err
+	}
+
+	_, err := c.run(ctx, host, command+" && chpasswd", username+":"+password+"\n")
+	return err
+}
+
+// setExpiration moves the expiry of an existing account
+// on the host to the end of the day of expiration.
+func (c *shellClient) setExpiration(ctx context.Context, host, username string, expiration time.Time) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+
+	_, err := c.run(ctx, host, "chage -E "+accountExpiryDate(expiration)+" "+username, "")
+	return err
+}
+
+// accountExpiryDate returns the date to pass to useradd -e or chage -E
+// so an account stops working no earlier than expiration. Account
+// expiry is kept in whole days and takes effect at the start of the
+// date, so the date is the day after expiration.
+func accountExpiryDate(expiration time.Time) string {
+	return expiration.UTC().AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+// deleteUser removes a local account and its home directory from the host.
+// An account that no longer exists is not treated as an error.
+func (c *shellClient) deleteUser(ctx context.Context, host, username string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+
+	_, err := c.run(ctx, host, "if id -u "+username+" >/dev/null 2>&1; then userdel -r "+username+"; fi", "")
+	return err
+}
+
+// userExists returns whether a local account with the name exists on the host.
+func (c *shellClient) userExists(ctx context.Context, host, username string) (bool, error) {
+	if !validUsername.MatchString(username) {
+		return false, fmt.Errorf("invalid username %q", username)
+	}
+
+	out, err := c.run(ctx, host, "if id -u "+username+" >/dev/null 2>&1; then echo exists; fi", "")
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(out) == "exists", nil
+}
+
+// listUsers returns the names of the local accounts on the host.
+func (c *shellClient) listUsers(ctx context.Context, host string) ([]string, error) {
+	out, err := c.run(ctx, host, "getent passwd | cut -d: -f1", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out), nil
+}
+
+// asUser wraps a command so it runs as the account instead of the admin
+// user. Files in the account's home directory are then opened with the
+// account's own permissions, so symlinks it placed there cannot point
+// the command at files the account could not change itself.
+func asUser(username, command string) string {
+	return "su -s /bin/sh " + username + " -c " + shellQuote(command)
+}
+
+// addAuthorizedKey appends a public key line to the account's
+// authorized_keys file, creating the file if needed.
+func (c *shellClient) addAuthorizedKey(ctx context.Context, host, username, authorizedKey string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+
+	command := asUser(username, `umask 077 && cd && mkdir -p .ssh && cat >> .ssh/authorized_keys`)
+
+	_, err := c.run(ctx, host, command, authorizedKey+"\n")
+	return err
+}
+
+// removeAuthorizedKey removes exactly the given public key line from the
+// account's authorized_keys file. Other keys are left untouched.
+func (c *shellClient) removeAuthorizedKey(ctx context.Context, host, username, authorizedKey string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+
+	command := `if id -u ` + username + ` >/dev/null 2>&1; then ` + asUser(username,
+		`if cd 2>/dev/null && [ -f .ssh/authorized_keys ]; then key=$(cat) && `+
+			`t=$(mktemp .ssh/authorized_keys.XXXXXX) && `+
+			`{ grep -vxF -- "$key" .ssh/authorized_keys > "$t" || [ $? -eq 1 ]; } && `+
+			`mv "$t" .ssh/authorized_keys; fi`) + `; fi`
+
+	_, err := c.run(ctx, host, command, authorizedKey+"\n")
+	return err
 }
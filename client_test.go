@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// newTestClient returns a client for the server's address.
+func newTestClient(t *testing.T, server *testSSHServer) *shellClient {
+	t.Helper()
+
+	client, err := newClient(&shellConfig{
+		Username: "admin",
+		Password: "admin-password",
+		URL:      server.addr,
+	}, &logical.InmemStorage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.close)
+	return client
+}
+
+func TestClient_SetPassword(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	if err := client.setPassword(context.Background(), "", "alice", "s3cret!"); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := server.commands()
+	if len(commands) != 1 {
+		t.Fatalf("expected 1 command, got %#v", commands)
+	}
+	if commands[0].Command != "chpasswd" {
+		t.Errorf("expected chpasswd, got %q", commands[0].Command)
+	}
+	if commands[0].Stdin != "alice:s3cret!\n" {
+		t.Errorf("expected the password on stdin, got %q", commands[0].Stdin)
+	}
+}
+
+func TestClient_SetPasswordInvalidUsername(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	if err := client.setPassword(context.Background(), "", "alice; reboot", "s3cret!"); err == nil {
+		t.Fatal("expected an error for an invalid username")
+	}
+	if commands := server.commands(); len(commands) != 0 {
+		t.Fatalf("expected no commands, got %#v", commands)
+	}
+}
+
+func TestClient_Run(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		if command == "false" {
+			return "", 1
+		}
+		return "hello\n", 0
+	})
+
+	out, err := client.run(context.Background(), "", "echo hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello\n" {
+		t.Errorf("expected the command's output, got %q", out)
+	}
+
+	_, err = client.run(context.Background(), "", "false", "")
+	if err == nil || !strings.Contains(err.Error(), "remote command failed") {
+		t.Fatalf("expected a remote command error, got %v", err)
+	}
+}
+
+func TestClient_RunUnreachableHost(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+	server.stop()
+
+	if _, err := client.run(context.Background(), "", "true", ""); err == nil {
+		t.Fatal("expected an error connecting to a stopped server")
+	}
+}
+
+func TestClient_AuthorizedKeysRunAsAccount(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHRlc3Q= vault"
+	if err := client.addAuthorizedKey(context.Background(), "", "alice", key); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.removeAuthorizedKey(context.Background(), "", "alice", key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, command := range server.commands() {
+		// Following links as the admin user would let the
+		// account overwrite files outside its home directory
+		if !strings.Contains(command.Command, "su -s /bin/sh alice -c ") {
+			t.Errorf("expected the command to run as the account, got %q", command.Command)
+		}
+		if strings.Contains(command.Command, "chown") {
+			t.Errorf("expected no ownership changes, got %q", command.Command)
+		}
+		if command.Stdin != key+"\n" {
+			t.Errorf("expected the key on stdin, got %q", command.Stdin)
+		}
+	}
+}
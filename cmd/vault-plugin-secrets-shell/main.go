@@ -3,12 +3,17 @@ package main
 import (
 	"os"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/plugin"
 	shell "github.com/joatmon08/vault-plugin-secrets-shell"
 )
 
+// statsdAddrEnv names the environment variable holding the address
+// of a statsd server to send the plugin's metrics to.
+const statsdAddrEnv = "VAULT_PLUGIN_SHELL_STATSD_ADDR"
+
 func main() {
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
@@ -17,14 +22,40 @@ func main() {
 	tlsConfig := apiClientMeta.GetTLSConfig()
 	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
 
+	logger := hclog.New(&hclog.LoggerOptions{})
+
+	if err := configureMetrics(os.Getenv(statsdAddrEnv)); err != nil {
+		logger.Error("error configuring metrics", "error", err)
+		os.Exit(1)
+	}
+
 	err := plugin.Serve(&plugin.ServeOpts{
 		BackendFactoryFunc: shell.Factory,
 		TLSProviderFunc:    tlsProviderFunc,
 	})
 	if err != nil {
-		logger := hclog.New(&hclog.LoggerOptions{})
-
 		logger.Error("plugin shutting down", "error", err)
 		os.Exit(1)
 	}
 }
+
+// configureMetrics sends the plugin's metrics to the statsd server at
+// addr. The plugin runs in a process of its own, so without a sink its
+// metrics would be discarded. An empty addr leaves metrics disabled.
+func configureMetrics(addr string) error {
+	if addr == "" {
+		return nil
+	}
+
+	sink, err := metrics.NewStatsdSink(addr)
+	if err != nil {
+		return err
+	}
+
+	config := metrics.DefaultConfig("vault")
+	config.EnableHostname = false
+	config.EnableRuntimeMetrics = false
+
+	_, err = metrics.NewGlobal(config, sink)
+	return err
+}
@@ -4,19 +4,96 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/template"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
 	credObjectType = "cred_object"
+
+	// defaultUsernameTemplate renders v-<role>-<8 random>, which
+	// fits within the 32 character limit for Linux account names.
+	defaultUsernameTemplate = `{{ printf "v-%s-%s" (.RoleName | replace "." "-" | truncate 21) (random 8) | lowercase }}`
 )
 
-// credObject defines a username and password
+// usernameMetadata holds the values a username template can refer to.
+type usernameMetadata struct {
+	RoleName    string
+	DisplayName string
+	EntityID    string
+}
+
+// credObject defines a username and either a
+// password or an SSH key pair
 type credObject struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+}
+
+// toResponseData returns response data for the credentials
+func (c *credObject) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"username": c.Username,
+	}
+	if c.Password != "" {
+		respData["password"] = c.Password
+	}
+	if c.PrivateKey != "" {
+		respData["private_key"] = c.PrivateKey
+		respData["public_key"] = c.PublicKey
+	}
+	return respData
+}
+
+// generateUsername renders the username template, or the default
+// template if it is empty, and checks that the result is a valid
+// Linux account name.
+func generateUsername(usernameTemplate string, metadata usernameMetadata) (string, error) {
+	if usernameTemplate == "" {
+		usernameTemplate = defaultUsernameTemplate
+	}
+
+	up, err := template.NewTemplate(template.Template(usernameTemplate))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse username template: %w", err)
+	}
+
+	username, err := up.Generate(metadata)
+	if err != nil {
+		return "", fmt.Errorf("unable to render username template: %w", err)
+	}
+
+	if !validUsername.MatchString(username) {
+		return "", fmt.Errorf("username template rendered %q, which is not a valid account name", username)
+	}
+
+	return username, nil
+}
+
+// checkUsernameTemplate renders the username template twice for the
+// same request and fails if both names are the same. Names that do not
+// vary would be issued twice on a host, and one lease would take over
+// or remove the account of another.
+func checkUsernameTemplate(usernameTemplate string, metadata usernameMetadata) error {
+	first, err := generateUsername(usernameTemplate, metadata)
+	if err != nil {
+		return err
+	}
+
+	second, err := generateUsername(usernameTemplate, metadata)
+	if err != nil {
+		return err
+	}
+
+	if first == second {
+		return fmt.Errorf("template rendered %q twice for the same request, add a random part such as (random 8)", first)
+	}
+	return nil
 }
 
 func (b *shellBackend) credObject() *framework.Secret {
@@ -31,19 +108,23 @@ func (b *shellBackend) credObject() *framework.Secret {
 				Type:        framework.TypeString,
 				Description: "My Credentials Object password",
 			},
+			"private_key": {
+				Type:        framework.TypeString,
+				Description: "My Credentials Object SSH private key",
+			},
+			"public_key": {
+				Type:        framework.TypeString,
+				Description: "My Credentials Object SSH public key",
+			},
 		},
-		Revoke: b.revoke,
-		Renew:  b.renew,
+		Revoke: b.recordLeaseEvent(historyOperationRevoke, b.revoke),
+		Renew:  b.recordLeaseEvent(historyOperationRenew, b.renew),
 	}
 }
 
-// revoke removes the credentials object from the Vault storage API and calls the client to revoke the token
-func (b *shellBackend) revoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	client, err := b.getClient(ctx, req.Storage)
-	if err != nil {
-		return nil, fmt.Errorf("error getting client: %w", err)
-	}
-
+// revoke removes the credentials object from the Vault storage API and calls the client to revoke the token.
+// Revocations that fail are queued for retry, marking the event pending, and the lease ends.
+func (b *shellBackend) revoke(ctx context.Context, req *logical.Request, d *framework.FieldData, event *historyEvent) (*logical.Response, error) {
 	username := ""
 	// We passed the username using InternalData from when we first created
 	// the secret.
@@ -55,14 +136,91 @@ func (b *shellBackend) revoke(ctx context.Context, req *logical.Request, d *fram
 		}
 	}
 
-	if err := revokeCredentials(ctx, client, username); err != nil {
-		return nil, fmt.Errorf("error revoking username: %w", err)
+	// Keys are removed from accounts that existed before the lease,
+	// while accounts created for the lease are deleted.
+	publicKey, _ := req.Secret.InternalData["public_key"].(string)
+	existingAccount, _ := req.Secret.InternalData["existing_account"].(bool)
+
+	host, _ := req.Secret.InternalData["host"].(string)
+	connection, hasConnection := req.Secret.InternalData["connection"].(string)
+	hostKeyPolicy, _ := req.Secret.InternalData["host_key_policy"].(string)
+	statements := internalStrings(req.Secret.InternalData["revocation_statements"])
+
+	roleName, _ := req.Secret.InternalData["role"].(string)
+	if roleName != "" {
+		roleEntry, err := b.getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving role: %w", err)
+		}
+
+		// The role may have been deleted since the lease was issued
+		if roleEntry != nil {
+			// Leases issued by earlier versions only stored the role,
+			// and were always issued on the role's host. Newer leases
+			// keep the statements they were issued with, even if the
+			// role was edited since.
+			if !hasConnection {
+				connection = roleEntry.Connection
+				hostKeyPolicy = roleEntry.HostKeyPolicy
+				statements = roleEntry.RevocationStatements
+			}
+			if host == "" {
+				host = roleEntry.Host
+			}
+		}
+	}
+
+	if host == "" {
+		return nil, errors.New("secret is missing host internal data and its role no longer exists")
+	}
+
+	client, err := b.getClient(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	client = client.withHostKeyPolicy(hostKeyPolicy)
+
+	release, err := client.acquire(ctx, host)
+	if err == nil {
+		err = revokeCredentials(ctx, client, host, username, publicKey, !existingAccount, statements)
+		release()
+	}
+	if err != nil {
+		// Vault gives up on a lease after a few failed attempts,
+		// so keep retrying until the credentials are removed
+		if queueErr := queueRevocation(ctx, req.Storage, &pendingRevocation{
+			LeaseID:              req.Secret.LeaseID,
+			Role:                 roleName,
+			Connection:           connection,
+			HostKeyPolicy:        hostKeyPolicy,
+			Host:                 host,
+			Username:             username,
+			PublicKey:            publicKey,
+			ExistingAccount:      existingAccount,
+			RevocationStatements: statements,
+		}, err); queueErr != nil {
+			return nil, fmt.Errorf("error revoking username: %w", errors.Join(err, queueErr))
+		}
+
+		// The plugin retries queued revocations itself,
+		// so Vault can consider the lease revoked
+		b.Logger().Warn("revocation failed and was queued for retry", "lease_id", req.Secret.LeaseID, "host", host, "username", username, "error", err)
+		event.Outcome = historyOutcomePending
+		event.Error = err.Error()
+		return nil, nil
+	}
+
+	if !existingAccount {
+		if err := req.Storage.Delete(ctx, issuedAccountKey(connection, host, username)); err != nil {
+			return nil, fmt.Errorf("error deleting issued account: %w", err)
+		}
 	}
 	return nil, nil
 }
 
-// renew calls the client to create a new password and stores it in the Vault storage API
-func (b *shellBackend) renew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+// renew extends the lease within the role and mount max TTL and moves
+// the account's expiry on the host to match
+func (b *shellBackend) renew(ctx context.Context, req *logical.Request, d *framework.FieldData, _ *historyEvent) (*logical.Response, error) {
 	roleRaw, ok := req.Secret.InternalData["role"]
 	if !ok {
 		return nil, fmt.Errorf("secret is missing role internal data")
@@ -79,28 +237,162 @@ func (b *shellBackend) renew(ctx context.Context, req *logical.Request, d *frame
 		return nil, errors.New("error retrieving role: role is nil")
 	}
 
+	connection, ok := req.Secret.InternalData["connection"].(string)
+	hostKeyPolicy, _ := req.Secret.InternalData["host_key_policy"].(string)
+	if !ok {
+		connection = roleEntry.Connection
+		hostKeyPolicy = roleEntry.HostKeyPolicy
+	}
+
+	config, err := getConfig(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration: %w", err)
+	}
+
+	// The requested increment is capped by the role, connection and
+	// mount max TTL, counted from when the lease was issued
+	roleTTL, roleMaxTTL := leaseTTLs(roleEntry, config)
+	ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, roleTTL, 0, roleMaxTTL, 0, req.Secret.IssueTime)
+	if err != nil {
+		return nil, err
+	}
+
 	resp := &logical.Response{Secret: req.Secret}
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = roleMaxTTL
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+
+	// Keys installed on existing accounts are removed on
+	// revocation, but the accounts themselves never expire
+	username, _ := req.Secret.InternalData["username"].(string)
+	existingAccount, _ := req.Secret.InternalData["existing_account"].(bool)
+	if existingAccount && len(roleEntry.RenewStatements) == 0 {
+		return resp, nil
+	}
 
-	if roleEntry.TTL > 0 {
-		resp.Secret.TTL = roleEntry.TTL
+	host, ok := req.Secret.InternalData["host"].(string)
+	if !ok {
+		host = roleEntry.Host
+	}
+
+	client, err := b.getClient(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	client = client.withHostKeyPolicy(hostKeyPolicy)
+
+	release, err := client.acquire(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Move the account's expiry on the host along with the lease
+	// so the account never outlives it
+	expiration := time.Now().Add(ttl)
+	if len(roleEntry.RenewStatements) > 0 {
+		err = client.runStatements(ctx, host, roleEntry.RenewStatements, statementValues(username, "", expiration))
+	} else {
+		err = client.setExpiration(ctx, host, username, expiration)
 	}
-	if roleEntry.MaxTTL > 0 {
-		resp.Secret.MaxTTL = roleEntry.MaxTTL
+	if err != nil {
+		return nil, fmt.Errorf("error renewing username: %w", err)
 	}
 
 	return resp, nil
 }
 
-// TODO: Implement code to retrieve username and password
-// You can reference https://github.com/hashicorp/vault-plugin-secrets-openldap/blob/main/rotation.go
-// for an example of how to rotate usernames and passwords using rotation periods.
-func getCredentials(ctx context.Context, c *shellClient) (*credObject, error) {
-	return &credObject{
-		Username: "foo",
-	}, nil
+// leaseTTLs returns the TTL and max TTL for the role's leases. Values the
+// role leaves at zero default to the connection's, and the connection's
+// max TTL caps the role's.
+func leaseTTLs(role *shellRoleEntry, config *shellConfig) (time.Duration, time.Duration) {
+	ttl, maxTTL := role.TTL, role.MaxTTL
+	if config == nil {
+		return ttl, maxTTL
+	}
+
+	if ttl == 0 {
+		ttl = config.TTL
+	}
+
+	if config.MaxTTL > 0 && (maxTTL == 0 || maxTTL > config.MaxTTL) {
+		maxTTL = config.MaxTTL
+	}
+
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	return ttl, maxTTL
+}
+
+// internalStrings converts a list read back from a lease's internal
+// data, which is decoded from JSON as []interface{}, to []string.
+func internalStrings(raw interface{}) []string {
+	switch list := raw.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		values := make([]string, 0, len(list))
+		for _, value := range list {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// getCredentials sets up the credentials on the host. It runs the
+// role's creation statements, or creates the account when createAccount
+// is set, and installs the public key for SSH key credentials.
+func getCredentials(ctx context.Context, c *shellClient, host string, creds *credObject, createAccount bool, statements []string, expiration time.Time) error {
+	if len(statements) > 0 {
+		if err := c.runStatements(ctx, host, statements, statementValues(creds.Username, creds.Password, expiration)); err != nil {
+			return err
+		}
+	} else if createAccount {
+		if err := c.createUser(ctx, host, creds.Username, creds.Password, expiration); err != nil {
+			return err
+		}
+	}
+
+	if creds.PublicKey != "" {
+		return c.addAuthorizedKey(ctx, host, creds.Username, creds.PublicKey)
+	}
+
+	return nil
 }
 
-// TODO: Implement code to revoke username and password
-func revokeCredentials(ctx context.Context, c *shellClient, username string) error {
+// revokeCredentials removes the credentials issued with a lease from the
+// host. The lease's public key is removed from existing accounts. Then
+// the role's revocation statements run, or accounts created for the
+// lease are deleted if the role has none.
+func revokeCredentials(ctx context.Context, c *shellClient, host, username, publicKey string, deleteAccount bool, statements []string) error {
+	if username == "" {
+		return errors.New("username was not defined")
+	}
+
+	if !deleteAccount {
+		if publicKey == "" {
+			return errors.New("public key was not defined")
+		}
+		if err := c.removeAuthorizedKey(ctx, host, username, publicKey); err != nil {
+			return err
+		}
+	}
+
+	if len(statements) > 0 {
+		return c.runStatements(ctx, host, statements, statementValues(username, "", time.Time{}))
+	}
+
+	if deleteAccount {
+		return c.deleteUser(ctx, host, username)
+	}
+
 	return nil
 }
@@ -0,0 +1,397 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCredentials_CreateAndDeleteAccount(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+		"ttl":  "1h",
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	username := resp.Data["username"].(string)
+	password := resp.Data["password"].(string)
+	if !strings.HasPrefix(username, "v-web-") {
+		t.Fatalf("expected a username generated for the role, got %q", username)
+	}
+
+	created := server.commandsContaining("useradd")
+	if len(created) != 1 {
+		t.Fatalf("expected 1 useradd command, got %#v", server.commands())
+	}
+	expiry := accountExpiryDate(time.Now().Add(time.Hour))
+	if want := "useradd -m -e " + expiry + " " + username + " && chpasswd"; created[0].Command != want {
+		t.Errorf("expected %q, got %q", want, created[0].Command)
+	}
+	if created[0].Stdin != username+":"+password+"\n" {
+		t.Errorf("expected the password on stdin, got %q", created[0].Stdin)
+	}
+	if strings.Contains(created[0].Command, password) {
+		t.Error("password must not appear in the command line")
+	}
+
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := server.commandsContaining("userdel")
+	if len(deleted) != 1 {
+		t.Fatalf("expected 1 userdel command, got %#v", server.commands())
+	}
+	if !strings.Contains(deleted[0].Command, "userdel -r "+username) {
+		t.Errorf("expected the lease's account to be deleted, got %q", deleted[0].Command)
+	}
+}
+
+func TestCredentials_CreateAccountFails(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+
+	// useradd fails without creating the account
+	server.handle(func(command, stdin string) (string, uint32) {
+		if strings.HasPrefix(command, "useradd") {
+			return "", 1
+		}
+		return "", 0
+	})
+
+	resp, err := b.HandleRequest(testContext(t), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath + "web",
+		Storage:   s,
+	})
+	if err == nil {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+
+	ids, err := framework.ListWAL(testContext(t), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("expected no WAL entry for an account that was not created, got %d", len(ids))
+	}
+
+	testRollback(t, b, s)
+	if n := len(server.commandsContaining("userdel")); n != 0 {
+		t.Errorf("expected nothing to be rolled back, got %d userdel commands", n)
+	}
+}
+
+func TestCredentials_AccountAlreadyExists(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+
+	// Every generated name is taken by an account on the host
+	server.handle(func(command, stdin string) (string, uint32) {
+		if strings.HasPrefix(command, "if id -u") {
+			return "exists\n", 0
+		}
+		if strings.HasPrefix(command, "useradd") {
+			return "", 9
+		}
+		return "", 0
+	})
+
+	resp, err := b.HandleRequest(testContext(t), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath + "web",
+		Storage:   s,
+	})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an error for the existing account, got %#v, %v", resp, err)
+	}
+	if n := len(server.commandsContaining("useradd")); n != 0 {
+		t.Fatalf("expected no account to be created, got %d useradd commands", n)
+	}
+
+	testRollback(t, b, s)
+	if n := len(server.commandsContaining("userdel")); n != 0 {
+		t.Errorf("expected the existing account to survive the rollback, got %d userdel commands", n)
+	}
+}
+
+func TestCredentials_SSHKeyExistingAccount(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"keys", map[string]interface{}{
+		"host":            server.addr,
+		"credential_type": credentialTypeSSHKey,
+		"username":        "alice",
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"keys", nil)
+	publicKey := resp.Data["public_key"].(string)
+	if resp.Data["private_key"] == "" {
+		t.Fatal("expected a private key")
+	}
+
+	added := server.commandsContaining("authorized_keys")
+	if len(added) != 1 {
+		t.Fatalf("expected the key to be installed, got %#v", server.commands())
+	}
+	if !strings.HasPrefix(added[0].Command, "su -s /bin/sh alice -c ") {
+		t.Errorf("expected the key to be installed as the account, got %q", added[0].Command)
+	}
+	if !strings.Contains(added[0].Command, "cat >> .ssh/authorized_keys") {
+		t.Errorf("expected the key to be appended, got %q", added[0].Command)
+	}
+	if added[0].Stdin != publicKey+"\n" {
+		t.Errorf("expected the public key on stdin, got %q", added[0].Stdin)
+	}
+	if len(server.commandsContaining("useradd")) != 0 {
+		t.Error("expected no account to be created for an existing account")
+	}
+
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := server.commandsContaining("grep -vxF")
+	if len(removed) != 1 {
+		t.Fatalf("expected the key to be removed, got %#v", server.commands())
+	}
+	if !strings.Contains(removed[0].Command, "su -s /bin/sh alice -c ") || !strings.Contains(removed[0].Command, "mktemp") {
+		t.Errorf("expected the key to be removed as the account through a temporary file, got %q", removed[0].Command)
+	}
+	if removed[0].Stdin != publicKey+"\n" {
+		t.Errorf("expected the public key on stdin, got %q", removed[0].Stdin)
+	}
+	if len(server.commandsContaining("userdel")) != 0 {
+		t.Error("expected the existing account to be kept")
+	}
+}
+
+func TestCredentials_RequestedHostRequiresKnownKey(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	other := newTestSSHServer(t)
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"fleet", map[string]interface{}{
+		"host":            server.addr,
+		"allowed_hosts":   "127.0.0.0/8",
+		"host_key_policy": hostKeyPolicyTOFU,
+	})
+
+	// The role's own host is trusted on first use
+	testRequest(t, b, s, logical.ReadOperation, credsPath+"fleet", nil)
+
+	// A requested host is not, so the admin password is never sent to it
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath + "fleet",
+		Storage:   s,
+		Data:      map[string]interface{}{"host": other.addr},
+	})
+	if err == nil {
+		t.Fatalf("expected a host without a known key to be refused, got %#v", resp)
+	}
+	if n := other.loginCount(); n != 0 {
+		t.Fatalf("expected no login on the requested host, got %d", n)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, knownHostsPath+other.addr, map[string]interface{}{
+		"public_key": string(ssh.MarshalAuthorizedKey(other.hostKey.PublicKey())),
+	})
+
+	resp = testRequest(t, b, s, logical.ReadOperation, credsPath+"fleet", map[string]interface{}{
+		"host": other.addr,
+	})
+	if resp.Secret.InternalData["host_key_policy"] != hostKeyPolicyStrict {
+		t.Errorf("expected the lease to keep the strict policy, got %v", resp.Secret.InternalData["host_key_policy"])
+	}
+	if n := len(other.commandsContaining("useradd")); n != 1 {
+		t.Errorf("expected the account to be created once the key is known, got %d", n)
+	}
+}
+
+func TestGenerateUsername(t *testing.T) {
+	metadata := usernameMetadata{RoleName: "web.prod", DisplayName: "token-alice"}
+
+	username, err := generateUsername("", metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(username, "v-web-prod-") || !validUsername.MatchString(username) {
+		t.Errorf("expected a valid username for the role, got %q", username)
+	}
+
+	username, err = generateUsername(`{{ printf "app-%s-%s" .DisplayName (random 4) | lowercase }}`, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(username, "app-token-alice-") || len(username) != len("app-token-alice-")+4 {
+		t.Errorf("expected the template to be rendered, got %q", username)
+	}
+
+	if _, err := generateUsername(`{{ printf "App %s" .DisplayName }}`, metadata); err == nil {
+		t.Error("expected an invalid account name to be rejected")
+	}
+}
+
+func TestCheckUsernameTemplate(t *testing.T) {
+	metadata := usernameMetadata{RoleName: "web", DisplayName: "token-alice"}
+
+	if err := checkUsernameTemplate("", metadata); err != nil {
+		t.Errorf("expected the default template to be accepted, got %v", err)
+	}
+	if err := checkUsernameTemplate(`{{ printf "app-%s" .DisplayName | lowercase }}`, metadata); err == nil {
+		t.Error("expected a template without a random part to be rejected")
+	}
+}
+
+func TestRole_UsernameTemplateWithoutRandomPart(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	resp, err := b.HandleRequest(testContext(t), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hostRolePath + "web",
+		Data: map[string]interface{}{
+			"host":              server.addr,
+			"username_template": `{{ printf "app-%s" .DisplayName | lowercase }}`,
+		},
+		Storage: s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "random") {
+		t.Fatalf("expected the template to be rejected, got %#v", resp)
+	}
+}
+
+func TestCredentials_RenewExtendsAccountExpiry(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host":    server.addr,
+		"ttl":     "1h",
+		"max_ttl": "24h",
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	username := resp.Data["username"].(string)
+	issued := time.Now()
+
+	renewed := testRenew(t, b, s, resp, issued, 12*time.Hour)
+	if renewed.Secret.TTL != 12*time.Hour {
+		t.Errorf("expected the requested increment, got %s", renewed.Secret.TTL)
+	}
+
+	// The max TTL counts from when the lease was issued
+	capped := testRenew(t, b, s, resp, issued.Add(-22*time.Hour), 12*time.Hour)
+	if capped.Secret.TTL > 2*time.Hour || capped.Secret.TTL < time.Hour {
+		t.Errorf("expected the increment to be capped by max_ttl, got %s", capped.Secret.TTL)
+	}
+
+	extended := server.commandsContaining("chage -E")
+	if len(extended) != 2 {
+		t.Fatalf("expected the expiry to move on each renewal, got %#v", server.commands())
+	}
+	want := "chage -E " + accountExpiryDate(time.Now().Add(12*time.Hour)) + " " + username
+	if extended[0].Command != want {
+		t.Errorf("expected %q, got %q", want, extended[0].Command)
+	}
+	want = "chage -E " + accountExpiryDate(time.Now().Add(capped.Secret.TTL)) + " " + username
+	if extended[1].Command != want {
+		t.Errorf("expected %q, got %q", want, extended[1].Command)
+	}
+}
+
+func TestCredentials_RevokeAfterRoleChanges(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	other := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	username := resp.Data["username"].(string)
+
+	// The lease still targets the host it was issued on
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": other.addr,
+	})
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.commandsContaining("userdel -r " + username)); n != 1 {
+		t.Errorf("expected the account to be deleted from the original host, got %d", n)
+	}
+	if n := len(other.commands()); n != 0 {
+		t.Errorf("expected the role's new host to be untouched, got %#v", other.commands())
+	}
+
+	resp = testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	username = resp.Data["username"].(string)
+
+	// and can be revoked once the role is gone
+	testRequest(t, b, s, logical.DeleteOperation, hostRolePath+"web", nil)
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(other.commandsContaining("userdel -r " + username)); n != 1 {
+		t.Errorf("expected the account to be deleted after the role was removed, got %d", n)
+	}
+}
+
+func TestCredentials_RevokeUsesLeaseStatements(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host":                  server.addr,
+		"revocation_statements": "pkill -u {{username}}; userdel -r {{username}}",
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	username := resp.Data["username"].(string)
+
+	// Later edits to the role only apply to new leases
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"revocation_statements": "usermod -L {{username}}",
+	})
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "pkill -u '" + username + "'; userdel -r '" + username + "'"
+	if revoked := server.commandsContaining(username); len(revoked) == 0 || revoked[len(revoked)-1].Command != want {
+		t.Errorf("expected the lease's statements to run, got %#v", server.commands())
+	}
+	if n := len(server.commandsContaining("usermod")); n != 0 {
+		t.Errorf("expected the role's new statements not to run, got %d", n)
+	}
+}
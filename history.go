@@ -0,0 +1,194 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// historyStoragePath holds the recent events of each role,
+	// by role type and name.
+	historyStoragePath = "history/"
+
+	// historySize is how many events are kept for each role.
+	historySize = 100
+
+	historyOperationIssue  = "issue"
+	historyOperationRenew  = "renew"
+	historyOperationRevoke = "revoke"
+	historyOperationRotate = "rotate"
+
+	historyOutcomeSuccess   = "success"
+	historyOutcomeFailure   = "failure"
+	historyOutcomePending   = "pending"
+	historyOutcomeAbandoned = "abandoned"
+)
+
+// historyEvent records an operation on credentials issued or
+// rotated for a role.
+type historyEvent struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Username  string    `json:"username,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	LeaseID   string    `json:"lease_id,omitempty"`
+	EntityID  string    `json:"entity_id,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// toResponseData returns response data for an event
+func (e *historyEvent) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"time":      e.Time,
+		"operation": e.Operation,
+		"username":  e.Username,
+		"host":      e.Host,
+		"lease_id":  e.LeaseID,
+		"entity_id": e.EntityID,
+		"outcome":   e.Outcome,
+	}
+	if e.Error != "" {
+		respData["error"] = e.Error
+	}
+	return respData
+}
+
+// roleHistory is a ring buffer of a role's most recent events.
+type roleHistory struct {
+	Events []historyEvent `json:"events"`
+
+	// Next is the event overwritten by the
+	// next one once the buffer is full
+	Next int `json:"next"`
+}
+
+// add adds the event, overwriting the oldest
+// event once the buffer is full.
+func (h *roleHistory) add(event historyEvent) {
+	if len(h.Events) < historySize {
+		h.Events = append(h.Events, event)
+		return
+	}
+	h.Events[h.Next%len(h.Events)] = event
+	h.Next = (h.Next + 1) % len(h.Events)
+}
+
+// ordered returns the events from oldest to newest.
+func (h *roleHistory) ordered() []historyEvent {
+	next := h.Next % max(len(h.Events), 1)
+	events := make([]historyEvent, 0, len(h.Events))
+	events = append(events, h.Events[next:]...)
+	return append(events, h.Events[:next]...)
+}
+
+// historyKey returns the storage key of the history of a role,
+// where rolePath is the path the role is stored under.
+func historyKey(rolePath, name string) string {
+	return historyStoragePath + rolePath + name
+}
+
+// recordEvent adds the outcome of an operation to the role's
+// history. Failing to record an event is logged rather than
+// failing the operation.
+func (b *shellBackend) recordEvent(ctx context.Context, s logical.Storage, rolePath, name string, event historyEvent, opErr error) {
+	if name == "" {
+		return
+	}
+
+	event.Time = time.Now()
+	if opErr != nil {
+		event.Outcome = historyOutcomeFailure
+		event.Error = opErr.Error()
+	} else if event.Outcome == "" {
+		event.Outcome = historyOutcomeSuccess
+	}
+
+	b.historyLock.Lock()
+	defer b.historyLock.Unlock()
+
+	history, err := getRoleHistory(ctx, s, rolePath, name)
+	if err == nil {
+		history.add(event)
+		err = setRoleHistory(ctx, s, rolePath, name, history)
+	}
+	if err != nil {
+		b.Logger().Warn("error recording role history", "role", name, "operation", event.Operation, "error", err)
+	}
+}
+
+// leaseOperationFunc is a renew or revoke callback for credentials
+// issued for a role. It sets the outcome of the event when the
+// operation returns no error but did not complete, as when a
+// revocation is queued for retry.
+type leaseOperationFunc func(ctx context.Context, req *logical.Request, d *framework.FieldData, event *historyEvent) (*logical.Response, error)
+
+// recordLeaseEvent wraps a renew or revoke callback for credentials
+// issued for a role, adding the outcome to the role's history and
+// emitting metrics for it.
+func (b *shellBackend) recordLeaseEvent(operation string, callback leaseOperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		roleName, _ := req.Secret.InternalData["role"].(string)
+		username, _ := req.Secret.InternalData["username"].(string)
+		host, _ := req.Secret.InternalData["host"].(string)
+		event := historyEvent{
+			Operation: operation,
+			Username:  username,
+			Host:      host,
+			LeaseID:   req.Secret.LeaseID,
+			EntityID:  req.EntityID,
+		}
+
+		start := time.Now()
+		resp, err := callback(ctx, req, d, &event)
+
+		// Pending operations failed on the host, so they are
+		// counted as errors even though the lease moves on
+		metricErr := err
+		if err == nil && event.Outcome == historyOutcomePending {
+			metricErr = errors.New(event.Error)
+		}
+		emitOperationMetrics(operation, roleName, host, start, metricErr)
+		b.recordEvent(ctx, req.Storage, hostRolePath, roleName, event, err)
+
+		return resp, err
+	}
+}
+
+// setRoleHistory adds the role's history to the Vault storage API
+func setRoleHistory(ctx context.Context, s logical.Storage, rolePath, name string, history *roleHistory) error {
+	entry, err := logical.StorageEntryJSON(historyKey(rolePath, name), history)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for role history")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getRoleHistory gets the role's history from the Vault storage API,
+// or an empty history if no events were recorded
+func getRoleHistory(ctx context.Context, s logical.Storage, rolePath, name string) (*roleHistory, error) {
+	entry, err := s.Get(ctx, historyKey(rolePath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	history := &roleHistory{}
+	if entry == nil {
+		return history, nil
+	}
+
+	if err := entry.DecodeJSON(history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,172 @@
+package secrets
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testHistory reads the events of a role from its history endpoint.
+func testHistory(t *testing.T, b *shellBackend, s logical.Storage, path string) []map[string]interface{} {
+	t.Helper()
+
+	resp := testRequest(t, b, s, logical.ReadOperation, path+"/history", nil)
+	if resp == nil {
+		t.Fatalf("expected history at %s", path)
+	}
+	return resp.Data["events"].([]map[string]interface{})
+}
+
+func TestHistory_HostRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host":    server.addr,
+		"ttl":     "1h",
+		"max_ttl": "24h",
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	username := resp.Data["username"].(string)
+	testRenew(t, b, s, resp, time.Now(), time.Hour)
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		if strings.HasPrefix(command, "useradd") {
+			return "", 9
+		}
+		return "", 0
+	})
+	if _, err := b.HandleRequest(testContext(t), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath + "web",
+		Storage:   s,
+	}); err == nil {
+		t.Fatal("expected issuing credentials to fail")
+	}
+
+	events := testHistory(t, b, s, hostRolePath+"web")
+	want := []struct{ operation, outcome string }{
+		{historyOperationIssue, historyOutcomeSuccess},
+		{historyOperationRenew, historyOutcomeSuccess},
+		{historyOperationRevoke, historyOutcomeSuccess},
+		{historyOperationIssue, historyOutcomeFailure},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %#v", len(want), events)
+	}
+	for i, w := range want {
+		if events[i]["operation"] != w.operation || events[i]["outcome"] != w.outcome {
+			t.Errorf("event %d: expected %s %s, got %#v", i, w.operation, w.outcome, events[i])
+		}
+	}
+	for _, event := range events[:3] {
+		if event["username"] != username || event["host"] != server.addr {
+			t.Errorf("expected the lease's account and host, got %#v", event)
+		}
+	}
+	if events[3]["error"] == nil {
+		t.Errorf("expected the failure to record its error, got %#v", events[3])
+	}
+}
+
+func TestHistory_StaticRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":     server.addr,
+		"username": "app",
+	})
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		return "", 1
+	})
+	role, err := getStaticRole(testContext(t), s, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.rotateStaticRole(testContext(t), s, role); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+
+	events := testHistory(t, b, s, staticRolePath+"app")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %#v", events)
+	}
+	for i, outcome := range []string{historyOutcomeSuccess, historyOutcomeFailure} {
+		if events[i]["operation"] != historyOperationRotate || events[i]["outcome"] != outcome {
+			t.Errorf("event %d: expected a rotate %s, got %#v", i, outcome, events[i])
+		}
+		if events[i]["username"] != "app" || events[i]["host"] != server.addr {
+			t.Errorf("expected the role's account and host, got %#v", events[i])
+		}
+	}
+}
+
+func TestHistory_MissingRole(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	resp := testRequest(t, b, s, logical.ReadOperation, hostRolePath+"missing/history", nil)
+	if resp != nil {
+		t.Fatalf("expected no history for a role without events, got %#v", resp)
+	}
+}
+
+func TestRoleHistory_KeepsNewestEvents(t *testing.T) {
+	var history roleHistory
+	for i := 0; i < historySize+10; i++ {
+		history.add(historyEvent{Username: strconv.Itoa(i)})
+	}
+
+	events := history.ordered()
+	if len(events) != historySize {
+		t.Fatalf("expected %d events, got %d", historySize, len(events))
+	}
+	if events[0].Username != "10" {
+		t.Errorf("expected the oldest kept event first, got %q", events[0].Username)
+	}
+	if last := events[len(events)-1].Username; last != strconv.Itoa(historySize+9) {
+		t.Errorf("expected the newest event last, got %q", last)
+	}
+}
+
+func TestHistory_KeptAfterRoleDeleted(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	testRequest(t, b, s, logical.DeleteOperation, hostRolePath+"web", nil)
+
+	// Leases of the deleted role are still recorded
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	events := testHistory(t, b, s, hostRolePath+"web")
+	if len(events) != 2 || events[0]["operation"] != historyOperationIssue || events[1]["operation"] != historyOperationRevoke {
+		t.Fatalf("expected the issue and revoke events, got %#v", events)
+	}
+
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":     server.addr,
+		"username": "app",
+	})
+	testRequest(t, b, s, logical.DeleteOperation, staticRolePath+"app", nil)
+
+	if events := testHistory(t, b, s, staticRolePath+"app"); len(events) != 1 {
+		t.Fatalf("expected the rotation to be kept, got %#v", events)
+	}
+}
@@ -0,0 +1,51 @@
+package secrets
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// validateAllowedHosts checks that each allowed_hosts entry is
+// a CIDR block or a valid glob pattern.
+func validateAllowedHosts(allowedHosts []string) error {
+	for _, pattern := range allowedHosts {
+		if strings.Contains(pattern, "/") {
+			if _, _, err := net.ParseCIDR(pattern); err != nil {
+				return fmt.Errorf("invalid CIDR %q in allowed_hosts: %w", pattern, err)
+			}
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q in allowed_hosts: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// hostMatches returns whether the host, with any port removed, matches
+// one of the allowed_hosts entries. Entries are either CIDR blocks,
+// which match IP addresses, or glob patterns such as "*.example.com".
+func hostMatches(allowedHosts []string, host string) bool {
+	hostname := strings.ToLower(strings.TrimPrefix(host, "ssh://"))
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+	hostname = strings.Trim(hostname, "[]")
+	ip := net.ParseIP(hostname)
+
+	for _, pattern := range allowedHosts {
+		if strings.Contains(pattern, "/") {
+			_, network, err := net.ParseCIDR(pattern)
+			if err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), hostname); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// hostKeyPolicyStrict only accepts host keys added to known-hosts.
+	hostKeyPolicyStrict = "strict"
+
+	// hostKeyPolicyTOFU pins the key a host presents on the first
+	// connection and rejects any other key after that.
+	hostKeyPolicyTOFU = "tofu"
+
+	// hostKeyPolicyInsecure accepts any host key. Only use it
+	// for development.
+	hostKeyPolicyInsecure = "insecure"
+
+	defaultHostKeyPolicy = hostKeyPolicyTOFU
+)
+
+var validHostKeyPolicies = map[string]bool{
+	hostKeyPolicyStrict:   true,
+	hostKeyPolicyTOFU:     true,
+	hostKeyPolicyInsecure: true,
+}
+
+// knownHost is the host key pinned for a host.
+type knownHost struct {
+	Host      string `json:"host"`
+	PublicKey string `json:"public_key"`
+}
+
+// publicKey parses the pinned host key.
+func (k *knownHost) publicKey() (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+	return key, err
+}
+
+// hostKeyError is returned when a host presents a key
+// that does not match its known host key.
+type hostKeyError struct {
+	host   string
+	reason string
+}
+
+func (e *hostKeyError) Error() string {
+	return fmt.Sprintf("host key verification failed for %s: %s", e.host, e.reason)
+}
+
+// knownHostName returns the name a host's key is stored under,
+// so web1, web1:22 and ssh://web1 all share one entry.
+func knownHostName(host string) string {
+	return strings.ToLower(hostAddress(host))
+}
+
+// checkHostKey verifies the key presented by the host at addr
+// against the known-hosts store according to the policy.
+func checkHostKey(ctx context.Context, s logical.Storage, policy, addr string, key ssh.PublicKey) error {
+	if policy == hostKeyPolicyInsecure {
+		return nil
+	}
+
+	name := knownHostName(addr)
+	known, err := getKnownHost(ctx, s, name)
+	if err != nil {
+		return fmt.Errorf("error reading known host key for %s: %w", name, err)
+	}
+
+	if known == nil {
+		if policy != hostKeyPolicyTOFU {
+			return &hostKeyError{
+				host:   name,
+				reason: fmt.Sprintf("no known host key, add one at %s%s", knownHostsPath, name),
+			}
+		}
+		return setKnownHost(ctx, s, name, &knownHost{
+			Host:      name,
+			PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		})
+	}
+
+	pinned, err := known.publicKey()
+	if err != nil {
+		return fmt.Errorf("error parsing known host key for %s: %w", name, err)
+	}
+
+	if !bytes.Equal(pinned.Marshal(), key.Marshal()) {
+		return &hostKeyError{
+			host: name,
+			reason: fmt.Sprintf("host key has changed from %s to %s, update %s%s if the change is expected",
+				ssh.FingerprintSHA256(pinned), ssh.FingerprintSHA256(key), knownHostsPath, name),
+		}
+	}
+
+	return nil
+}
+
+// setKnownHost adds the host key to the Vault storage API
+func setKnownHost(ctx context.Context, s logical.Storage, name string, known *knownHost) error {
+	entry, err := logical.StorageEntryJSON(knownHostsStoragePath+name, known)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for known host")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getKnownHost gets the host key from the Vault storage API
+func getKnownHost(ctx context.Context, s logical.Storage, name string) (*knownHost, error) {
+	entry, err := s.Get(ctx, knownHostsStoragePath+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var known knownHost
+
+	if err := entry.DecodeJSON(&known); err != nil {
+		return nil, err
+	}
+	return &known, nil
+}
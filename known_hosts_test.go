@@ -0,0 +1,157 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKey_TOFUPinsFirstKey(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	if _, err := client.run(context.Background(), "", "true", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	known, err := getKnownHost(context.Background(), client.storage, knownHostName(server.addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known == nil {
+		t.Fatal("expected the host key to be pinned")
+	}
+	if known.PublicKey+"\n" != string(ssh.MarshalAuthorizedKey(server.hostKey.PublicKey())) {
+		t.Errorf("expected the server's key to be pinned, got %q", known.PublicKey)
+	}
+}
+
+func TestHostKey_Mismatch(t *testing.T) {
+	for _, policy := range []string{hostKeyPolicyStrict, hostKeyPolicyTOFU} {
+		t.Run(policy, func(t *testing.T) {
+			server := newTestSSHServer(t)
+			client := newTestClient(t, server).withHostKeyPolicy(policy)
+
+			// The key pinned for the address belongs to another server
+			other := newTestSSHServer(t)
+			if err := setKnownHost(context.Background(), client.storage, knownHostName(server.addr), &knownHost{
+				Host:      knownHostName(server.addr),
+				PublicKey: string(ssh.MarshalAuthorizedKey(other.hostKey.PublicKey())),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := client.run(context.Background(), "", "true", "")
+			var hostKeyErr *hostKeyError
+			if !errors.As(err, &hostKeyErr) {
+				t.Fatalf("expected a host key error, got %v", err)
+			}
+			if n := server.loginCount(); n != 0 {
+				t.Errorf("expected the password never to be sent, got %d logins", n)
+			}
+		})
+	}
+}
+
+func TestHostKey_StrictWithoutKnownKey(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      configPath,
+		Storage:   s,
+		Data: map[string]interface{}{
+			"username":        "admin",
+			"password":        "admin-password",
+			"url":             server.addr,
+			"host_key_policy": hostKeyPolicyStrict,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected verifying the connection to fail, got %#v", resp)
+	}
+	if n := server.loginCount(); n != 0 {
+		t.Errorf("expected the password never to be sent, got %d logins", n)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, knownHostsPath+server.addr, map[string]interface{}{
+		"public_key": string(ssh.MarshalAuthorizedKey(server.hostKey.PublicKey())),
+	})
+	testRequest(t, b, s, logical.CreateOperation, configPath, map[string]interface{}{
+		"username":        "admin",
+		"password":        "admin-password",
+		"url":             server.addr,
+		"host_key_policy": hostKeyPolicyStrict,
+	})
+}
+
+func TestHostKey_StaticRoleAndLibraryPolicy(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	// The connection pins keys on first use, but the account's host
+	// has no known key and is only reached with strict checking
+	other := newTestSSHServer(t)
+	for path, data := range map[string]map[string]interface{}{
+		staticRolePath + "app": {
+			"host":            other.addr,
+			"username":        "app",
+			"host_key_policy": hostKeyPolicyStrict,
+		},
+		libraryPath + "shared": {
+			"host":                  other.addr,
+			"service_account_names": "shared1",
+			"host_key_policy":       hostKeyPolicyStrict,
+		},
+	} {
+		if _, err := b.HandleRequest(testContext(t), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   s,
+		}); err == nil {
+			t.Errorf("%s: expected the rotation to fail without a known host key", path)
+		}
+	}
+	if n := other.loginCount(); n != 0 {
+		t.Errorf("expected the password never to be sent, got %d logins", n)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, knownHostsPath+other.addr, map[string]interface{}{
+		"public_key": string(ssh.MarshalAuthorizedKey(other.hostKey.PublicKey())),
+	})
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":            other.addr,
+		"username":        "app",
+		"host_key_policy": hostKeyPolicyStrict,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, staticRolePath+"app", nil)
+	if resp.Data["host_key_policy"] != hostKeyPolicyStrict {
+		t.Errorf("expected the static role's host_key_policy, got %#v", resp.Data["host_key_policy"])
+	}
+
+	resp, err := b.HandleRequest(testContext(t), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      libraryPath + "shared",
+		Data: map[string]interface{}{
+			"host":                  other.addr,
+			"service_account_names": "shared1",
+			"host_key_policy":       "unknown",
+		},
+		Storage: s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an unknown host_key_policy, got %#v", resp)
+	}
+}
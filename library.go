@@ -0,0 +1,258 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	libraryAccountType        = "library_account"
+	libraryAccountPath        = "library-account/"
+	libraryAccountStoragePath = "library-account/"
+)
+
+// libraryAccount stores the current password and check-out
+// status of an account in a library set.
+type libraryAccount struct {
+	Username            string    `json:"username"`
+	Password            string    `json:"password"`
+	IsAvailable         bool      `json:"is_available"`
+	CheckOutID          string    `json:"check_out_id,omitempty"`
+	BorrowerEntityID    string    `json:"borrower_entity_id,omitempty"`
+	BorrowerClientToken string    `json:"borrower_client_token,omitempty"`
+	LastVaultRotation   time.Time `json:"last_vault_rotation"`
+
+	// PasswordHistory holds hashes of the account's
+	// previous passwords, which are never reused
+	PasswordHistory passwordHistory `json:"password_history,omitempty"`
+}
+
+// libraryAccountKey returns the storage key for an account in a set.
+func libraryAccountKey(set, username string) string {
+	return libraryAccountPath + set + "/" + username
+}
+
+func (b *shellBackend) libraryAccount() *framework.Secret {
+	return &framework.Secret{
+		Type: libraryAccountType,
+		Fields: map[string]*framework.FieldSchema{
+			"service_account_name": {
+				Type:        framework.TypeString,
+				Description: "Checked out account name",
+			},
+			"password": {
+				Type:        framework.TypeString,
+				Description: "Checked out account password",
+			},
+		},
+		Revoke: b.libraryCheckInRevoke,
+		Renew:  b.libraryCheckOutRenew,
+	}
+}
+
+// libraryCheckInRevoke checks the account back in when its lease ends.
+func (b *shellBackend) libraryCheckInRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	setName, username, err := libraryInternalData(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	b.libraryLock.Lock()
+	defer b.libraryLock.Unlock()
+
+	account, err := getLibraryAccount(ctx, req.Storage, setName, username)
+	if err != nil {
+		return nil, err
+	}
+
+	// The account was checked in early and may have been
+	// checked out again under another lease
+	checkOutID, _ := req.Secret.InternalData["check_out_id"].(string)
+	if account == nil || account.CheckOutID != checkOutID {
+		return nil, nil
+	}
+
+	if err := b.checkIn(ctx, req.Storage, setName, username); err != nil {
+		return nil, fmt.Errorf("error checking in %q: %w", username, err)
+	}
+	return nil, nil
+}
+
+// libraryCheckOutRenew extends the check-out up to the set's max TTL
+// as long as the account is still checked out.
+func (b *shellBackend) libraryCheckOutRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	setName, username, err := libraryInternalData(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := getLibrarySet(ctx, req.Storage, setName)
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, fmt.Errorf("library set %q no longer exists", setName)
+	}
+
+	account, err := getLibraryAccount(ctx, req.Storage, setName, username)
+	if err != nil {
+		return nil, err
+	}
+
+	checkOutID, _ := req.Secret.InternalData["check_out_id"].(string)
+	if account == nil || account.IsAvailable || account.CheckOutID != checkOutID {
+		return nil, fmt.Errorf("%q is no longer checked out", username)
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	resp.Secret.TTL = set.TTL
+	resp.Secret.MaxTTL = set.MaxTTL
+
+	return resp, nil
+}
+
+// libraryInternalData reads the set and account name from a check-out lease.
+func libraryInternalData(secret *logical.Secret) (string, string, error) {
+	setName, ok := secret.InternalData["set"].(string)
+	if !ok {
+		return "", "", errors.New("secret is missing set internal data")
+	}
+
+	username, ok := secret.InternalData["service_account_name"].(string)
+	if !ok {
+		return "", "", errors.New("secret is missing service_account_name internal data")
+	}
+
+	return setName, username, nil
+}
+
+// checkIn rotates the password of a checked out account so the previous
+// holder's copy no longer works, and marks it available. Callers must
+// hold the library lock.
+func (b *shellBackend) checkIn(ctx context.Context, s logical.Storage, setName, username string) error {
+	account, err := getLibraryAccount(ctx, s, setName, username)
+	if err != nil {
+		return err
+	}
+
+	// The account was removed or already checked in
+	if account == nil || account.IsAvailable {
+		return nil
+	}
+
+	set, err := getLibrarySet(ctx, s, setName)
+	if err != nil {
+		return err
+	}
+
+	if set == nil {
+		return fmt.Errorf("library set %q no longer exists", setName)
+	}
+
+	return b.rotateLibraryAccount(ctx, s, set, account)
+}
+
+// rotateLibraryAccount sets a newly generated password for the account
+// on the set's host and stores it as available. Callers must hold the
+// library lock.
+func (b *shellBackend) rotateLibraryAccount(ctx context.Context, s logical.Storage, set *librarySet, account *libraryAccount) (err error) {
+	defer func(start time.Time) {
+		emitOperationMetrics(historyOperationRotate, set.Name, set.Host, start, err)
+	}(time.Now())
+
+	client, err := b.getClient(ctx, s, set.Connection)
+	if err != nil {
+		return err
+	}
+	client = client.withHostKeyPolicy(set.HostKeyPolicy)
+
+	release, err := client.acquire(ctx, set.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	config, err := getConfig(ctx, s, set.Connection)
+	if err != nil {
+		return fmt.Errorf("unable to read configuration: %w", err)
+	}
+
+	password, err := b.generateUniquePassword(ctx, config.PasswordPolicy, account.PasswordHistory)
+	if err != nil {
+		return err
+	}
+
+	history, err := account.PasswordHistory.add(password)
+	if err != nil {
+		return err
+	}
+
+	// Record the rotation so the host is reset to the stored
+	// password if the new one is set but never stored.
+	walID, err := framework.PutWAL(ctx, s, walTypeLibraryRotation, &walAccount{
+		Role:       set.Name,
+		Connection: set.Connection,
+		Host:       set.Host,
+		Username:   account.Username,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	if err := client.setPassword(ctx, set.Host, account.Username, password); err != nil {
+		return err
+	}
+
+	account.Password = password
+	account.PasswordHistory = history
+	account.IsAvailable = true
+	account.CheckOutID = ""
+	account.BorrowerEntityID = ""
+	account.BorrowerClientToken = ""
+	account.LastVaultRotation = time.Now()
+
+	if err := setLibraryAccount(ctx, s, set.Name, account); err != nil {
+		return err
+	}
+
+	return framework.DeleteWAL(ctx, s, walID)
+}
+
+// setLibraryAccount adds the account status to the Vault storage API
+func setLibraryAccount(ctx context.Context, s logical.Storage, setName string, account *libraryAccount) error {
+	entry, err := logical.StorageEntryJSON(libraryAccountKey(setName, account.Username), account)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for library account")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getLibraryAccount gets the account status from the Vault storage API
+func getLibraryAccount(ctx context.Context, s logical.Storage, setName, username string) (*libraryAccount, error) {
+	entry, err := s.Get(ctx, libraryAccountKey(setName, username))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var account libraryAccount
+
+	if err := entry.DecodeJSON(&account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
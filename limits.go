@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/time/rate"
+)
+
+// hostLimiter limits the operations a connection runs, both against
+// each host and across all of its hosts, in how many run at once and
+// how many start each second. A limit of zero leaves that dimension
+// unlimited.
+type hostLimiter struct {
+	mu                sync.Mutex
+	hosts             map[string]*operationLimit
+	maxConcurrent     int
+	requestsPerSecond float64
+
+	// connection limits the operations across every host
+	connection *operationLimit
+}
+
+// operationLimit tracks the operations running within a single limit.
+type operationLimit struct {
+	scope             string
+	slots             chan struct{}
+	limiter           *rate.Limiter
+	maxConcurrent     int
+	requestsPerSecond float64
+}
+
+func newHostLimiter(maxConcurrent int, requestsPerSecond float64, connectionMaxConcurrent int, connectionRequestsPerSecond float64) *hostLimiter {
+	return &hostLimiter{
+		hosts:             make(map[string]*operationLimit),
+		maxConcurrent:     maxConcurrent,
+		requestsPerSecond: requestsPerSecond,
+		connection:        newOperationLimit("across the connection", connectionMaxConcurrent, connectionRequestsPerSecond),
+	}
+}
+
+func newOperationLimit(scope string, maxConcurrent int, requestsPerSecond float64) *operationLimit {
+	limit := &operationLimit{
+		scope:             scope,
+		maxConcurrent:     maxConcurrent,
+		requestsPerSecond: requestsPerSecond,
+	}
+	if maxConcurrent > 0 {
+		limit.slots = make(chan struct{}, maxConcurrent)
+	}
+	if requestsPerSecond > 0 {
+		burst := int(math.Ceil(requestsPerSecond))
+		limit.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), burst)
+	}
+	return limit
+}
+
+// sameLimits returns whether the limiter enforces the given limits.
+func (l *hostLimiter) sameLimits(maxConcurrent int, requestsPerSecond float64, connectionMaxConcurrent int, connectionRequestsPerSecond float64) bool {
+	return l.maxConcurrent == maxConcurrent &&
+		l.requestsPerSecond == requestsPerSecond &&
+		l.connection.maxConcurrent == connectionMaxConcurrent &&
+		l.connection.requestsPerSecond == connectionRequestsPerSecond
+}
+
+// host returns the limits for the address, creating them on first use.
+func (l *hostLimiter) host(addr string) *operationLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.hosts[addr]
+	if !ok {
+		limit = newOperationLimit("per host", l.maxConcurrent, l.requestsPerSecond)
+		l.hosts[addr] = limit
+	}
+	return limit
+}
+
+// acquire waits until an operation can start against the address and
+// returns a function that ends it. Operations queue until ctx is done,
+// then fail with a 429 error. The host's limit is always taken before
+// the connection's, so an operation holding a connection slot never
+// waits for a host slot.
+func (l *hostLimiter) acquire(ctx context.Context, addr string) (func(), error) {
+	releaseHost, err := l.host(addr).acquire(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseConnection, err := l.connection.acquire(ctx, addr)
+	if err != nil {
+		releaseHost()
+		return nil, err
+	}
+
+	return func() {
+		releaseConnection()
+		releaseHost()
+	}, nil
+}
+
+// acquire waits until an operation against the address can start
+// within the limit and returns a function that ends it.
+func (l *operationLimit) acquire(ctx context.Context, addr string) (func(), error) {
+	if l.limiter != nil {
+		if err := l.limiter.Wait(ctx); err != nil {
+			return nil, tooManyRequestsError(addr, fmt.Sprintf("more than %g requests per second %s", l.requestsPerSecond, l.scope))
+		}
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, tooManyRequestsError(addr, fmt.Sprintf("more than %d concurrent operations %s", l.maxConcurrent, l.scope))
+	}
+}
+
+// tooManyRequestsError returns an error that Vault responds to with
+// a 429 status code.
+func tooManyRequestsError(addr, reason string) error {
+	return logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf("too many requests to %s: %s, try again later", addr, reason))
+}
+
+// acquire waits until an operation can start against the host within
+// the connection's limits, and returns a function that ends it.
+func (c *shellClient) acquire(ctx context.Context, host string) (func(), error) {
+	return c.limiter.acquire(ctx, c.address(host))
+}
+
+// connectionLimiter returns the limiter shared by every client of the
+// connection, so clients recreated after a configuration change, and
+// the short-lived clients that verify or rotate the connection, count
+// against the same limits. The limiter is replaced when the limits in
+// config change.
+func (b *shellBackend) connectionLimiter(connection string, config *shellConfig) *hostLimiter {
+	if connection == "" {
+		connection = defaultConnectionName
+	}
+
+	b.limitersLock.Lock()
+	defer b.limitersLock.Unlock()
+
+	limiter, ok := b.limiters[connection]
+	if !ok || !limiter.sameLimits(config.MaxConcurrentOperations, config.RequestsPerSecond, config.ConnectionMaxConcurrentOperations, config.ConnectionRequestsPerSecond) {
+		limiter = newHostLimiter(config.MaxConcurrentOperations, config.RequestsPerSecond, config.ConnectionMaxConcurrentOperations, config.ConnectionRequestsPerSecond)
+		b.limiters[connection] = limiter
+	}
+	return limiter
+}
@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testShortContext returns a context that is done shortly, for
+// operations expected to wait on a limit and give up.
+func testShortContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// assertTooManyRequests fails the test unless err is a 429 error.
+func assertTooManyRequests(t *testing.T, err error) {
+	t.Helper()
+
+	var coded logical.HTTPCodedError
+	if !errors.As(err, &coded) || coded.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+}
+
+func TestHostLimiter_PerHost(t *testing.T) {
+	limiter := newHostLimiter(1, 0, 0, 0)
+
+	release, err := limiter.acquire(testContext(t), "a:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = limiter.acquire(testShortContext(t), "a:22")
+	assertTooManyRequests(t, err)
+
+	other, err := limiter.acquire(testShortContext(t), "b:22")
+	if err != nil {
+		t.Fatalf("expected other hosts to have limits of their own, got %v", err)
+	}
+	other()
+
+	release()
+	release, err = limiter.acquire(testShortContext(t), "a:22")
+	if err != nil {
+		t.Fatalf("expected the host to be free once released, got %v", err)
+	}
+	release()
+}
+
+func TestHostLimiter_Connection(t *testing.T) {
+	limiter := newHostLimiter(1, 0, 1, 0)
+
+	release, err := limiter.acquire(testContext(t), "a:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = limiter.acquire(testShortContext(t), "b:22")
+	assertTooManyRequests(t, err)
+	if !strings.Contains(err.Error(), "across the connection") {
+		t.Errorf("expected the connection's limit to be reported, got %v", err)
+	}
+
+	// The host's slot is returned when the connection's limit is hit
+	release()
+	release, err = limiter.acquire(testShortContext(t), "b:22")
+	if err != nil {
+		t.Fatalf("expected the host to be free after the failed attempt, got %v", err)
+	}
+	release()
+}
+
+func TestHostLimiter_ConnectionRate(t *testing.T) {
+	limiter := newHostLimiter(0, 0, 0, 1)
+
+	release, err := limiter.acquire(testContext(t), "a:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	_, err = limiter.acquire(testShortContext(t), "b:22")
+	assertTooManyRequests(t, err)
+}
+
+// testLimitedConnection configures the default connection for the
+// server to run one operation at a time, and holds that operation's
+// slot until the test ends.
+func testLimitedConnection(t *testing.T, b *shellBackend, s logical.Storage, server *testSSHServer) {
+	t.Helper()
+
+	testRequest(t, b, s, logical.CreateOperation, configPath, map[string]interface{}{
+		"username":                             "admin",
+		"password":                             "admin-password",
+		"url":                                  server.addr,
+		"connection_max_concurrent_operations": 1,
+	})
+
+	client, err := b.getClient(testContext(t), s, defaultConnectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := client.acquire(testContext(t), "other.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(release)
+}
+
+func TestLimits_Credentials(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testLimitedConnection(t, b, s, server)
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+
+	_, err := b.HandleRequest(testShortContext(t), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath + "web",
+		Storage:   s,
+	})
+	assertTooManyRequests(t, err)
+	if n := len(server.commandsContaining("useradd")); n != 0 {
+		t.Fatalf("expected no account to be created, got %d useradd commands", n)
+	}
+}
+
+func TestLimits_VerifyConnection(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testLimitedConnection(t, b, s, server)
+	logins := server.loginCount()
+
+	// The limit outlives the client that is reset by the write
+	resp, err := b.HandleRequest(testShortContext(t), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Data: map[string]interface{}{
+			"username":                             "admin",
+			"password":                             "new-password",
+			"url":                                  server.addr,
+			"connection_max_concurrent_operations": 1,
+		},
+		Storage: s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "too many requests") {
+		t.Fatalf("expected the verification to wait on the connection's limit, got %#v", resp)
+	}
+	if server.loginCount() != logins {
+		t.Error("expected no login while the connection is at its limit")
+	}
+}
+
+func TestLimits_Tidy(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testLimitedConnection(t, b, s, server)
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+
+	result, err := b.tidyAccounts(testShortContext(t), s, &tidyConfig{UsernamePrefix: "v-"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.errs) != 1 || !strings.Contains(result.errs[0], "too many requests") {
+		t.Fatalf("expected listing accounts to wait on the connection's limit, got %#v", result.errs)
+	}
+	if n := len(server.commandsContaining("getent")); n != 0 {
+		t.Fatalf("expected no accounts to be listed, got %d commands", n)
+	}
+}
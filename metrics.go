@@ -0,0 +1,44 @@
+package secrets
+
+import (
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// metricsPrefix is the prefix of the metrics the backend emits.
+var metricsPrefix = []string{"secrets", "shell"}
+
+// metricsKey returns the key of a metric under metricsPrefix.
+func metricsKey(names ...string) []string {
+	return append(append([]string{}, metricsPrefix...), names...)
+}
+
+// emitOperationMetrics times an issue, renew, revoke or rotate
+// operation for a role's credentials and counts it, along with
+// a separate count of the operations that failed.
+func emitOperationMetrics(operation, role, host string, start time.Time, err error) {
+	labels := []metrics.Label{
+		{Name: "role", Value: role},
+		{Name: "host", Value: host},
+	}
+
+	metrics.MeasureSinceWithLabels(metricsKey(operation), start, labels)
+	metrics.IncrCounterWithLabels(metricsKey(operation, "count"), 1, labels)
+	if err != nil {
+		metrics.IncrCounterWithLabels(metricsKey(operation, "error"), 1, labels)
+	}
+}
+
+// emitCommandMetrics times a command run on a host and
+// counts the commands that failed.
+func emitCommandMetrics(host string, start time.Time, err error) {
+	labels := []metrics.Label{
+		{Name: "host", Value: host},
+	}
+
+	metrics.MeasureSinceWithLabels(metricsKey("command"), start, labels)
+	if err != nil {
+		metrics.IncrCounterWithLabels(metricsKey("command", "error"), 1, labels)
+	}
+}
@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testMetrics sends metrics to an in-memory sink until the test ends.
+func testMetrics(t *testing.T) *metrics.InmemSink {
+	t.Helper()
+
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	config := metrics.DefaultConfig("")
+	config.EnableHostname = false
+	config.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(config, sink); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		metrics.NewGlobal(config, &metrics.BlackholeSink{})
+	})
+	return sink
+}
+
+// counterTotal returns the total of the counter with the key
+// and labels across every interval the sink holds.
+func counterTotal(sink *metrics.InmemSink, key string, labels ...metrics.Label) float64 {
+	name := key
+	for _, label := range labels {
+		name += ";" + label.Name + "=" + label.Value
+	}
+
+	var total float64
+	for _, interval := range sink.Data() {
+		interval.RLock()
+		if counter, ok := interval.Counters[name]; ok {
+			total += counter.Sum
+		}
+		interval.RUnlock()
+	}
+	return total
+}
+
+func TestMetrics_LibraryCheckIn(t *testing.T) {
+	sink := testMetrics(t)
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.CreateOperation, libraryPath+"team", map[string]interface{}{
+		"host":                  server.addr,
+		"service_account_names": "svc1",
+	})
+
+	labels := []metrics.Label{
+		{Name: "role", Value: "team"},
+		{Name: "host", Value: server.addr},
+	}
+	if n := counterTotal(sink, "secrets.shell.rotate.count", labels...); n != 1 {
+		t.Fatalf("expected the set's account rotation to be counted, got %v", n)
+	}
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, libraryPath+"team/check-out", nil)
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		return "", 1
+	})
+	if _, err := testRevoke(t, b, s, resp); err == nil {
+		t.Fatal("expected the check-in to fail")
+	}
+
+	if n := counterTotal(sink, "secrets.shell.rotate.count", labels...); n != 2 {
+		t.Errorf("expected the check-in rotation to be counted, got %v", n)
+	}
+	if n := counterTotal(sink, "secrets.shell.rotate.error", labels...); n != 1 {
+		t.Errorf("expected the failed check-in rotation to be counted, got %v", n)
+	}
+}
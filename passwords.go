@@ -2,13 +2,95 @@ package secrets
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 
 	"github.com/hashicorp/go-secure-stdlib/base62"
 )
 
+const (
+	// passwordHistorySize is how many previous passwords are
+	// remembered for static role and library accounts.
+	passwordHistorySize = 24
+
+	// maxPasswordAttempts limits how often a password that
+	// matches the history is generated again.
+	maxPasswordAttempts = 10
+
+	passwordSaltSize = 16
+)
+
+// passwordHash is a salted SHA-256 hash of a previous password.
+type passwordHash struct {
+	Salt string `json:"salt"`
+	Hash string `json:"hash"`
+}
+
+// passwordHistory holds hashes of an account's passwords,
+// most recent first.
+type passwordHistory []passwordHash
+
 func (b *shellBackend) generatePassword(ctx context.Context, policyName string) (password string, err error) {
 	if policyName != "" {
 		return b.System().GeneratePasswordFromPolicy(ctx, policyName)
 	}
 	return base62.Random(36)
 }
+
+// generateUniquePassword generates a password that does not match
+// any password in the account's history.
+func (b *shellBackend) generateUniquePassword(ctx context.Context, policyName string, history passwordHistory) (string, error) {
+	for i := 0; i < maxPasswordAttempts; i++ {
+		password, err := b.generatePassword(ctx, policyName)
+		if err != nil {
+			return "", err
+		}
+		if !history.contains(password) {
+			return password, nil
+		}
+	}
+
+	return "", errors.New("unable to generate a password that was not used before, check the password policy")
+}
+
+// contains returns whether the password matches a hash in the history.
+func (h passwordHistory) contains(password string) bool {
+	for _, previous := range h {
+		salt, err := base64.StdEncoding.DecodeString(previous.Salt)
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hashPassword(salt, password)), []byte(previous.Hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// add returns the history with the password added, dropping
+// the oldest hashes beyond passwordHistorySize.
+func (h passwordHistory) add(password string) (passwordHistory, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	updated := append(passwordHistory{{
+		Salt: base64.StdEncoding.EncodeToString(salt),
+		Hash: hashPassword(salt, password),
+	}}, h...)
+	if len(updated) > passwordHistorySize {
+		updated = updated[:passwordHistorySize]
+	}
+
+	return updated, nil
+}
+
+// hashPassword returns the base64 encoded SHA-256 hash of the salted password.
+func hashPassword(salt []byte, password string) string {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,162 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testPasswordPolicy registers a password policy on the backend's
+// system view that generates whatever password currently points to.
+func testPasswordPolicy(t *testing.T, b *shellBackend, name string, password *string) {
+	t.Helper()
+
+	system, ok := b.System().(*logical.StaticSystemView)
+	if !ok {
+		t.Fatalf("expected a static system view, got %T", b.System())
+	}
+	system.SetPasswordPolicy(name, func() (string, error) {
+		return *password, nil
+	})
+}
+
+func TestPasswordPolicy_Role(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	legacy := "Legacy01"
+	testPasswordPolicy(t, b, "legacy", &legacy)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"legacy", map[string]interface{}{
+		"host":            server.addr,
+		"password_policy": "legacy",
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, hostRolePath+"legacy", nil)
+	if resp.Data["password_policy"] != "legacy" {
+		t.Errorf("expected the role's password_policy, got %#v", resp.Data["password_policy"])
+	}
+
+	resp = testRequest(t, b, s, logical.ReadOperation, credsPath+"legacy", nil)
+	if resp.Data["password"] != legacy {
+		t.Fatalf("expected a password from the role's policy, got %q", resp.Data["password"])
+	}
+
+	created := server.commandsContaining("useradd")
+	if len(created) != 1 {
+		t.Fatalf("expected 1 useradd command, got %#v", server.commands())
+	}
+	if want := resp.Data["username"].(string) + ":" + legacy + "\n"; created[0].Stdin != want {
+		t.Errorf("expected %q on stdin, got %q", want, created[0].Stdin)
+	}
+}
+
+func TestPasswordPolicy_RoleValidation(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	resp, err := b.HandleRequest(testContext(t), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hostRolePath + "web",
+		Data: map[string]interface{}{
+			"host":            server.addr,
+			"password_policy": "missing",
+		},
+		Storage: s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an unknown password policy, got %#v", resp)
+	}
+
+	role, err := b.getRole(testContext(t), s, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != nil {
+		t.Fatalf("expected the role not to be stored, got %#v", role)
+	}
+}
+
+func TestPasswordHistory_RefusesReuse(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+
+	next := "first"
+	testPasswordPolicy(t, b, "fixed", &next)
+
+	testRequest(t, b, s, logical.CreateOperation, configPath, map[string]interface{}{
+		"username":        "admin",
+		"password":        "admin-password",
+		"url":             server.addr,
+		"password_policy": "fixed",
+	})
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":     server.addr,
+		"username": "app",
+	})
+
+	rotate := func() error {
+		role, err := getStaticRole(testContext(t), s, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.rotateStaticRole(testContext(t), s, role)
+	}
+
+	if err := rotate(); err == nil {
+		t.Fatal("expected the current password not to be reused")
+	}
+
+	next = "second"
+	if err := rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Earlier passwords are refused too, not only the current one
+	next = "first"
+	if err := rotate(); err == nil {
+		t.Fatal("expected an earlier password not to be reused")
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, staticCredsPath+"app", nil)
+	if resp.Data["password"] != "second" {
+		t.Errorf("expected the last password that was set, got %q", resp.Data["password"])
+	}
+
+	var set []string
+	for _, command := range server.commandsContaining("chpasswd") {
+		set = append(set, command.Stdin)
+	}
+	if len(set) != 2 || set[0] != "app:first\n" || set[1] != "app:second\n" {
+		t.Errorf("expected only unused passwords to be set on the host, got %q", set)
+	}
+}
+
+func TestPasswordHistory_KeepsRecentPasswords(t *testing.T) {
+	var history passwordHistory
+	for i := 0; i <= passwordHistorySize; i++ {
+		var err error
+		history, err = history.add(string(rune('a' + i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(history) != passwordHistorySize {
+		t.Fatalf("expected %d passwords, got %d", passwordHistorySize, len(history))
+	}
+	if history.contains("a") {
+		t.Error("expected the oldest password to be dropped")
+	}
+	if !history.contains(string(rune('a' + passwordHistorySize))) {
+		t.Error("expected the newest password to be kept")
+	}
+	if history[0].Hash == hashPassword(nil, string(rune('a'+passwordHistorySize))) {
+		t.Error("expected passwords to be salted")
+	}
+}
@@ -4,45 +4,106 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
-	configStoragePath = "config"
-	configPath        = "config"
+	// configStoragePath holds the single configuration written
+	// before named connections existed. It is migrated to the
+	// default connection.
+	configStoragePath     = "config"
+	configPath            = "config"
+	connectionStoragePath = "config/"
+	connectionPath        = "config/"
+
+	defaultConnectionName = "default"
 )
 
+// reservedConnectionNames are used by other paths under config/.
+var reservedConnectionNames = map[string]bool{
+	"ca":          true,
+	"rotate-root": true,
+	"tidy":        true,
+}
+
 type shellConfig struct {
 	Username       string `json:"username"`
 	Password       string `json:"password"`
 	URL            string `json:"url"`
 	PasswordPolicy string `json:"password_policy,omitempty"`
+	HostKeyPolicy  string `json:"host_key_policy,omitempty"`
+
+	// TTL and MaxTTL are the defaults for roles
+	// that use the connection
+	TTL    time.Duration `json:"ttl,omitempty"`
+	MaxTTL time.Duration `json:"max_ttl,omitempty"`
+
+	// MaxConcurrentOperations and RequestsPerSecond limit the
+	// operations on each host reached through the connection
+	MaxConcurrentOperations int     `json:"max_concurrent_operations,omitempty"`
+	RequestsPerSecond       float64 `json:"requests_per_second,omitempty"`
+
+	// ConnectionMaxConcurrentOperations and ConnectionRequestsPerSecond
+	// limit the operations across all hosts of the connection
+	ConnectionMaxConcurrentOperations int     `json:"connection_max_concurrent_operations,omitempty"`
+	ConnectionRequestsPerSecond       float64 `json:"connection_requests_per_second,omitempty"`
 }
 
+// pathConfig extends the Vault API with a `/config` endpoint for the
+// default connection and `/config/<name>` endpoints for named connections.
 func pathConfig(b *shellBackend) []*framework.Path {
+	operations := map[logical.Operation]framework.OperationHandler{
+		logical.CreateOperation: &framework.PathOperation{
+			Callback: b.pathConfigWrite,
+		},
+		logical.UpdateOperation: &framework.PathOperation{
+			Callback: b.pathConfigWrite,
+		},
+		logical.ReadOperation: &framework.PathOperation{
+			Callback: b.pathConfigRead,
+		},
+		logical.DeleteOperation: &framework.PathOperation{
+			Callback: b.pathConfigDelete,
+		},
+	}
+
+	connectionFields := b.configFields()
+	connectionFields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeLowerCaseString,
+		Description: "Name of the connection",
+		Required:    true,
+	}
+
 	return []*framework.Path{
 		{
-			Pattern: configPath,
-			Fields:  b.configFields(),
+			Pattern:         configPath,
+			Fields:          b.configFields(),
+			Operations:      operations,
+			ExistenceCheck:  b.pathConfigExistenceCheck,
+			HelpSynopsis:    "Some secrets engine configuration",
+			HelpDescription: "This path configures the default connection using a username, password, and URL.",
+		},
+		{
+			Pattern: connectionPath + "?$",
 			Operations: map[logical.Operation]framework.OperationHandler{
-				logical.CreateOperation: &framework.PathOperation{
-					Callback: b.pathConfigWrite,
-				},
-				logical.UpdateOperation: &framework.PathOperation{
-					Callback: b.pathConfigWrite,
-				},
-				logical.ReadOperation: &framework.PathOperation{
-					Callback: b.pathConfigRead,
-				},
-				logical.DeleteOperation: &framework.PathOperation{
-					Callback: b.pathConfigDelete,
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathConfigList,
 				},
 			},
+			HelpSynopsis:    "List the configured connections",
+			HelpDescription: "Connections will be listed by name.",
+		},
+		{
+			Pattern:         connectionPath + framework.GenericNameRegex("name"),
+			Fields:          connectionFields,
+			Operations:      operations,
 			ExistenceCheck:  b.pathConfigExistenceCheck,
-			HelpSynopsis:    "Some secrets engine configuration",
-			HelpDescription: "This path configures some secrets engine using a username, password, and URL.",
+			HelpSynopsis:    "Configure a named connection",
+			HelpDescription: "This path configures a named connection using a username, password, and URL. Roles select it with their connection parameter.",
 		},
 	}
 }
@@ -81,47 +142,131 @@ func (b *shellBackend) configFields() map[string]*framework.FieldSchema {
 			Description: "Password policy to use to generate passwords",
 			Required:    false,
 		},
+		"host_key_policy": {
+			Type:          framework.TypeLowerCaseString,
+			Description:   "How host keys are verified: strict only trusts keys added at known-hosts/, tofu pins the key seen on the first connection, and insecure accepts any key.",
+			Default:       defaultHostKeyPolicy,
+			AllowedValues: []interface{}{hostKeyPolicyStrict, hostKeyPolicyTOFU, hostKeyPolicyInsecure},
+		},
 		"ttl": {
 			Type:        framework.TypeDurationSecond,
-			Description: "The default password time-to-live.",
+			Description: "The default password time-to-live for roles that do not set one.",
 		},
 		"max_ttl": {
 			Type:        framework.TypeDurationSecond,
-			Description: "The maximum password time-to-live.",
+			Description: "The maximum password time-to-live. Roles cannot exceed it.",
 		},
+		"max_concurrent_operations": {
+			Type:        framework.TypeInt,
+			Description: "Maximum number of operations to run at once on each host. Set to 0 for no limit.",
+		},
+		"requests_per_second": {
+			Type:        framework.TypeFloat,
+			Description: "Maximum number of operations to start each second on each host. Set to 0 for no limit.",
+		},
+		"connection_max_concurrent_operations": {
+			Type:        framework.TypeInt,
+			Description: "Maximum number of operations to run at once across all hosts of the connection. Set to 0 for no limit.",
+		},
+		"connection_requests_per_second": {
+			Type:        framework.TypeFloat,
+			Description: "Maximum number of operations to start each second across all hosts of the connection. Set to 0 for no limit.",
+		},
+		"verify_connection": {
+			Type:        framework.TypeBool,
+			Description: "Log in to the URL with the username and password before saving the connection. Defaults to true.",
+			Default:     true,
+		},
+	}
+}
+
+// connectionName returns the connection a config request refers to.
+// Requests to `config` refer to the default connection.
+func connectionName(data *framework.FieldData) string {
+	if name, ok := data.GetOk("name"); ok {
+		return name.(string)
 	}
+	return defaultConnectionName
 }
 
 // pathConfigExistenceCheck verifies if the configuration exists.
 func (b *shellBackend) pathConfigExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
-	out, err := req.Storage.Get(ctx, req.Path)
+	config, err := getConfig(ctx, req.Storage, connectionName(data))
 	if err != nil {
 		return false, fmt.Errorf("existence check failed: %w", err)
 	}
 
-	return out != nil, nil
+	return config != nil, nil
+}
+
+// pathConfigList lists the names of the configured connections.
+func (b *shellBackend) pathConfigList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, connectionStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default connection may not have been migrated yet
+	hasDefault := false
+	for _, entry := range entries {
+		hasDefault = hasDefault || entry == defaultConnectionName
+	}
+	if !hasDefault {
+		legacy, err := req.Storage.Get(ctx, configStoragePath)
+		if err != nil {
+			return nil, err
+		}
+		if legacy != nil {
+			entries = append(entries, defaultConnectionName)
+		}
+	}
+
+	return logical.ListResponse(entries), nil
 }
 
 // pathConfigRead reads the configuration and outputs non-sensitive information.
 func (b *shellBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	config, err := getConfig(ctx, req.Storage)
+	config, err := getConfig(ctx, req.Storage, connectionName(data))
 	if err != nil {
 		return nil, err
 	}
 
+	if config == nil {
+		return nil, nil
+	}
+
+	hostKeyPolicy := config.HostKeyPolicy
+	if hostKeyPolicy == "" {
+		hostKeyPolicy = defaultHostKeyPolicy
+	}
+
 	// "password" is intentionally not returned by this endpoint
 	return &logical.Response{
 		Data: map[string]interface{}{
 			"username":        config.Username,
 			"url":             config.URL,
 			"password_policy": config.PasswordPolicy,
+			"host_key_policy": hostKeyPolicy,
+			"ttl":             config.TTL.Seconds(),
+			"max_ttl":         config.MaxTTL.Seconds(),
+
+			"max_concurrent_operations": config.MaxConcurrentOperations,
+			"requests_per_second":       config.RequestsPerSecond,
+
+			"connection_max_concurrent_operations": config.ConnectionMaxConcurrentOperations,
+			"connection_requests_per_second":       config.ConnectionRequestsPerSecond,
 		},
 	}, nil
 }
 
 // pathConfigWrite updates the configuration for the backend
 func (b *shellBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	config, err := getConfig(ctx, req.Storage)
+	name := connectionName(data)
+	if reservedConnectionNames[name] {
+		return logical.ErrorResponse("%q is reserved and cannot be used as a connection name", name), nil
+	}
+
+	config, err := getConfig(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
@@ -141,43 +286,137 @@ func (b *shellBackend) pathConfigWrite(ctx context.Context, req *logical.Request
 	config.URL = url
 	config.PasswordPolicy = passwordPolicy
 
+	// Updates that leave out host_key_policy keep the stored one,
+	// so a strict connection is never relaxed by accident
+	if hostKeyPolicy, ok := data.GetOk("host_key_policy"); ok {
+		config.HostKeyPolicy = hostKeyPolicy.(string)
+	} else if config.HostKeyPolicy == "" {
+		config.HostKeyPolicy = data.Get("host_key_policy").(string)
+	}
+	if !validHostKeyPolicies[config.HostKeyPolicy] {
+		return logical.ErrorResponse("host_key_policy must be one of strict, tofu or insecure"), nil
+	}
+
 	password, ok := data.GetOk("password")
 	if ok {
 		config.Password = password.(string)
 	}
 
-	entry, err := logical.StorageEntryJSON(configStoragePath, config)
-	if err != nil {
-		return nil, err
+	if ttlRaw, ok := data.GetOk("ttl"); ok {
+		config.TTL = time.Duration(ttlRaw.(int)) * time.Second
+	}
+
+	if maxTTLRaw, ok := data.GetOk("max_ttl"); ok {
+		config.MaxTTL = time.Duration(maxTTLRaw.(int)) * time.Second
+	}
+
+	if config.MaxTTL != 0 && config.TTL > config.MaxTTL {
+		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
+	}
+
+	if v, ok := data.GetOk("max_concurrent_operations"); ok {
+		config.MaxConcurrentOperations = v.(int)
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	if v, ok := data.GetOk("requests_per_second"); ok {
+		config.RequestsPerSecond = v.(float64)
+	}
+
+	if v, ok := data.GetOk("connection_max_concurrent_operations"); ok {
+		config.ConnectionMaxConcurrentOperations = v.(int)
+	}
+
+	if v, ok := data.GetOk("connection_requests_per_second"); ok {
+		config.ConnectionRequestsPerSecond = v.(float64)
+	}
+
+	if config.MaxConcurrentOperations < 0 {
+		return logical.ErrorResponse("max_concurrent_operations cannot be negative"), nil
+	}
+
+	if config.RequestsPerSecond < 0 {
+		return logical.ErrorResponse("requests_per_second cannot be negative"), nil
+	}
+
+	if config.ConnectionMaxConcurrentOperations < 0 {
+		return logical.ErrorResponse("connection_max_concurrent_operations cannot be negative"), nil
+	}
+
+	if config.ConnectionRequestsPerSecond < 0 {
+		return logical.ErrorResponse("connection_requests_per_second cannot be negative"), nil
+	}
+
+	// Catch a mistyped URL or password now instead
+	// of when credentials are first requested
+	if data.Get("verify_connection").(bool) {
+		if err := b.verifyConnection(ctx, req.Storage, name, config); err != nil {
+			return logical.ErrorResponse("error verifying connection: %s", err), nil
+		}
+	}
+
+	if err := setConfig(ctx, req.Storage, name, config); err != nil {
 		return nil, err
 	}
 
 	// reset the client so the next invocation will pick up the new configuration
-	b.reset()
+	b.resetClient(name)
 
 	return nil, nil
 }
 
 // pathConfigDelete removes the configuration for the backend
 func (b *shellBackend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, configStoragePath)
+	name := connectionName(data)
+
+	err := req.Storage.Delete(ctx, connectionStoragePath+name)
+	if err == nil && name == defaultConnectionName {
+		err = req.Storage.Delete(ctx, configStoragePath)
+	}
 
 	if err == nil {
-		b.reset()
+		b.resetClient(name)
 	}
 
 	return nil, err
 }
 
-func getConfig(ctx context.Context, s logical.Storage) (*shellConfig, error) {
-	entry, err := s.Get(ctx, configStoragePath)
+// setConfig adds the connection's configuration to the Vault storage API
+func setConfig(ctx context.Context, s logical.Storage, name string, config *shellConfig) error {
+	entry, err := logical.StorageEntryJSON(connectionStoragePath+name, config)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	// The default connection replaces the configuration
+	// written before named connections existed
+	if name == defaultConnectionName {
+		return s.Delete(ctx, configStoragePath)
+	}
+
+	return nil
+}
+
+func getConfig(ctx context.Context, s logical.Storage, name string) (*shellConfig, error) {
+	if name == "" {
+		name = defaultConnectionName
+	}
+
+	entry, err := s.Get(ctx, connectionStoragePath+name)
 	if err != nil {
 		return nil, err
 	}
 
+	if entry == nil && name == defaultConnectionName {
+		entry, err = s.Get(ctx, configStoragePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if entry == nil {
 		return nil, nil
 	}
@@ -190,3 +429,73 @@ func getConfig(ctx context.Context, s logical.Storage) (*shellConfig, error) {
 	// return the config, we are done
 	return config, nil
 }
+
+// validateConnection returns an error response if the
+// connection a role refers to is not configured.
+func validateConnection(ctx context.Context, s logical.Storage, name string) (*logical.Response, error) {
+	config, err := getConfig(ctx, s, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("connection %q is not configured", name), nil
+	}
+
+	return nil, nil
+}
+
+// verifyConnection logs in to the connection's URL with a client
+// of its own, so the mount's pooled connections are left alone.
+// The login counts against the connection's limits.
+func (b *shellBackend) verifyConnection(ctx context.Context, s logical.Storage, name string, config *shellConfig) error {
+	client, err := newClient(config, s)
+	if err != nil {
+		return err
+	}
+	defer client.close()
+	client.limiter = b.connectionLimiter(name, config)
+
+	release, err := client.acquire(ctx, config.URL)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return client.verify(ctx)
+}
+
+// migrateConfig moves the configuration written before named
+// connections existed to the default connection.
+func migrateConfig(ctx context.Context, s logical.Storage) error {
+	legacy, err := s.Get(ctx, configStoragePath)
+	if err != nil || legacy == nil {
+		return err
+	}
+
+	current, err := s.Get(ctx, connectionStoragePath+defaultConnectionName)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		legacy.Key = connectionStoragePath + defaultConnectionName
+		if err := s.Put(ctx, legacy); err != nil {
+			return fmt.Errorf("error migrating configuration: %w", err)
+		}
+	}
+
+	return s.Delete(ctx, configStoragePath)
+}
+
+// connectionFromStorageKey returns the connection a
+// configuration storage key belongs to.
+func connectionFromStorageKey(key string) (string, bool) {
+	if key == configStoragePath {
+		return defaultConnectionName, true
+	}
+	if strings.HasPrefix(key, connectionStoragePath) {
+		return strings.TrimPrefix(key, connectionStoragePath), true
+	}
+	return "", false
+}
@@ -0,0 +1,220 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	caStoragePath = "ca"
+	caPath        = "config/ca"
+	publicKeyPath = "public_key"
+)
+
+// shellCA stores the key pair the backend uses
+// to sign SSH user certificates.
+type shellCA struct {
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key"`
+}
+
+// signer parses the CA private key into an SSH signer.
+func (c *shellCA) signer() (ssh.Signer, error) {
+	return ssh.ParsePrivateKey([]byte(c.PrivateKey))
+}
+
+// pathConfigCA extends the Vault API with a `/config/ca` endpoint
+// to manage the CA key pair, and an unauthenticated `/public_key`
+// endpoint so hosts can fetch the CA public key.
+func pathConfigCA(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: caPath,
+			Fields: map[string]*framework.FieldSchema{
+				"generate_signing_key": {
+					Type:        framework.TypeBool,
+					Description: "Generate a new CA key pair. Ignored if private_key is set.",
+					Default:     true,
+				},
+				"private_key": {
+					Type:        framework.TypeString,
+					Description: "CA private key in PEM format, if not generated by Vault.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"key_type": {
+					Type:          framework.TypeLowerCaseString,
+					Description:   "Type of CA key to generate, either ed25519 or rsa.",
+					Default:       keyTypeED25519,
+					AllowedValues: []interface{}{keyTypeED25519, keyTypeRSA},
+				},
+				"key_bits": {
+					Type:        framework.TypeInt,
+					Description: "Number of bits for a generated rsa key. Defaults to 4096.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathConfigCAWrite,
+				},
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathConfigCARead,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathConfigCADelete,
+				},
+			},
+			HelpSynopsis:    pathConfigCAHelpSyn,
+			HelpDescription: pathConfigCAHelpDesc,
+		},
+		{
+			Pattern: publicKeyPath,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathPublicKeyRead,
+				},
+			},
+			HelpSynopsis:    pathPublicKeyHelpSyn,
+			HelpDescription: pathPublicKeyHelpDesc,
+		},
+	}
+}
+
+// pathConfigCAWrite stores a provided or newly generated CA key pair
+func (b *shellBackend) pathConfigCAWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	ca, err := getCA(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if ca != nil {
+		return logical.ErrorResponse("a CA key pair is already configured, delete it before writing a new one"), nil
+	}
+
+	ca = new(shellCA)
+
+	if privateKey, ok := d.GetOk("private_key"); ok {
+		ca.PrivateKey = privateKey.(string)
+	} else if d.Get("generate_signing_key").(bool) {
+		keyType := d.Get("key_type").(string)
+		keyBits := d.Get("key_bits").(int)
+		if keyType == keyTypeRSA && keyBits != 0 && !validKeyBits[keyBits] {
+			return logical.ErrorResponse("key_bits must be one of 2048, 3072 or 4096"), nil
+		}
+
+		ca.PrivateKey, _, err = generateSSHKeyPair(keyType, keyBits, "ca")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return logical.ErrorResponse("private_key must be set when generate_signing_key is false"), nil
+	}
+
+	signer, err := ca.signer()
+	if err != nil {
+		return logical.ErrorResponse("error parsing private_key: %s", err), nil
+	}
+	ca.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	entry, err := logical.StorageEntryJSON(caStoragePath, ca)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": ca.PublicKey,
+		},
+	}, nil
+}
+
+// pathConfigCARead returns the CA public key. The private key
+// is intentionally not returned by this endpoint.
+func (b *shellBackend) pathConfigCARead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	ca, err := getCA(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if ca == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": ca.PublicKey,
+		},
+	}, nil
+}
+
+// pathConfigCADelete removes the CA key pair
+func (b *shellBackend) pathConfigCADelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete(ctx, caStoragePath)
+}
+
+// pathPublicKeyRead returns the CA public key in authorized_keys
+// format so it can be written straight to TrustedUserCAKeys.
+func (b *shellBackend) pathPublicKeyRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	ca, err := getCA(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if ca == nil {
+		return logical.ErrorResponse("no CA key pair is configured"), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "text/plain",
+			logical.HTTPRawBody:     []byte(ca.PublicKey + "\n"),
+			logical.HTTPStatusCode:  200,
+		},
+	}, nil
+}
+
+func getCA(ctx context.Context, s logical.Storage) (*shellCA, error) {
+	entry, err := s.Get(ctx, caStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	ca := new(shellCA)
+	if err := entry.DecodeJSON(ca); err != nil {
+		return nil, fmt.Errorf("error reading CA configuration: %w", err)
+	}
+
+	return ca, nil
+}
+
+const pathConfigCAHelpSyn = `
+Configure the CA key pair used to sign SSH certificates.
+`
+
+const pathConfigCAHelpDesc = `
+This path generates or imports the key pair the backend uses to sign
+SSH user certificates. Only the public key can be read back.
+`
+
+const pathPublicKeyHelpSyn = `
+Retrieve the CA public key.
+`
+
+const pathPublicKeyHelpDesc = `
+This path returns the CA public key without authentication. Hosts can
+add it to TrustedUserCAKeys to accept certificates signed by Vault.
+`
@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfig_NamedConnections(t *testing.T) {
+	b, s := getTestBackend(t)
+	defaultServer := newTestSSHServer(t)
+	dmzServer := newTestSSHServer(t)
+	testConfigure(t, b, s, defaultServer)
+
+	testRequest(t, b, s, logical.CreateOperation, connectionPath+"dmz", map[string]interface{}{
+		"username": "dmz-admin",
+		"password": "dmz-password",
+		"url":      dmzServer.addr,
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"dmz", map[string]interface{}{
+		"host":       dmzServer.addr,
+		"connection": "dmz",
+	})
+	testRequest(t, b, s, logical.ReadOperation, credsPath+"dmz", nil)
+
+	if n := len(dmzServer.commandsContaining("useradd")); n != 1 {
+		t.Errorf("expected the account to be created through the dmz connection, got %d", n)
+	}
+	if n := len(defaultServer.commandsContaining("useradd")); n != 0 {
+		t.Errorf("expected the default connection to be unused, got %d", n)
+	}
+
+	resp := testRequest(t, b, s, logical.ListOperation, connectionPath, nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 2 {
+		t.Errorf("expected 2 connections, got %v", keys)
+	}
+}
+
+func TestConfig_UpdateKeepsHostKeyPolicy(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+
+	testRequest(t, b, s, logical.CreateOperation, configPath, map[string]interface{}{
+		"username":          "admin",
+		"password":          "admin-password",
+		"url":               server.addr,
+		"host_key_policy":   hostKeyPolicyStrict,
+		"verify_connection": false,
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, configPath, map[string]interface{}{
+		"username":          "admin",
+		"password":          "new-password",
+		"url":               server.addr,
+		"verify_connection": false,
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, configPath, nil)
+	if policy := resp.Data["host_key_policy"]; policy != hostKeyPolicyStrict {
+		t.Fatalf("expected the strict policy to be kept, got %v", policy)
+	}
+}
+
+func TestConfig_TTLDefaults(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+
+	testRequest(t, b, s, logical.CreateOperation, configPath, map[string]interface{}{
+		"username": "admin",
+		"password": "admin-password",
+		"url":      server.addr,
+		"ttl":      "2h",
+		"max_ttl":  "4h",
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	if resp.Secret.TTL != 2*time.Hour || resp.Secret.MaxTTL != 4*time.Hour {
+		t.Errorf("expected the connection's TTLs, got %s and %s", resp.Secret.TTL, resp.Secret.MaxTTL)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hostRolePath + "long",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"host":    server.addr,
+			"max_ttl": "8h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected a max_ttl above the connection's to be rejected, got %#v", resp)
+	}
+}
+
+func TestConfig_VerifyConnection(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+
+	testConfigure(t, b, s, server)
+	if commands := server.commands(); len(commands) != 1 || commands[0].Command != "true" {
+		t.Fatalf("expected the connection to be verified, got %#v", commands)
+	}
+
+	server.stop()
+
+	data := map[string]interface{}{
+		"username": "admin",
+		"password": "wrong-password",
+		"url":      server.addr,
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an unreachable host to be rejected, got %#v", resp)
+	}
+
+	config, err := getConfig(context.Background(), s, defaultConnectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Password != "admin-password" {
+		t.Error("expected the previous configuration to be kept")
+	}
+
+	data["verify_connection"] = false
+	testRequest(t, b, s, logical.UpdateOperation, configPath, data)
+}
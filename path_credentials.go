@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -28,6 +29,10 @@ func pathCredentials(b *shellBackend) []*framework.Path {
 					Description: "Name of the role",
 					Required:    true,
 				},
+				"host": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Host to issue credentials on. Must match the role's allowed_hosts and have a key at known-hosts/. Defaults to the role's host.",
+				},
 			},
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.ReadOperation:   b.pathCredentialsRead,
@@ -54,52 +59,180 @@ func (b *shellBackend) pathCredentialsRead(ctx context.Context, req *logical.Req
 		return nil, errors.New("error retrieving role: role is nil")
 	}
 
-	return b.create(ctx, req, roleEntry)
+	if roleEntry.credentialType() == credentialTypeCertificate {
+		return logical.ErrorResponse("role %q signs certificates, use %s%s instead", roleName, signPath, roleName), nil
+	}
+
+	host, err := roleEntry.resolveHost(d.Get("host").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	start := time.Now()
+	resp, err := b.create(ctx, req, roleEntry, host)
+	emitOperationMetrics(historyOperationIssue, roleEntry.Name, host, start, err)
+
+	event := historyEvent{
+		Operation: historyOperationIssue,
+		Host:      host,
+		EntityID:  req.EntityID,
+	}
+	if resp != nil && resp.Secret != nil {
+		event.Username, _ = resp.Secret.InternalData["username"].(string)
+	}
+	b.recordEvent(ctx, req.Storage, hostRolePath, roleEntry.Name, event, err)
+
+	return resp, err
 }
 
 // create to store into the Vault backend, generates
 // a response with the secrets information, and checks the TTL and MaxTTL attributes.
-func (b *shellBackend) create(ctx context.Context, req *logical.Request, role *shellRoleEntry) (*logical.Response, error) {
-	client, err := b.getClient(ctx, req.Storage)
+func (b *shellBackend) create(ctx context.Context, req *logical.Request, role *shellRoleEntry, host string) (*logical.Response, error) {
+	client, err := b.getClient(ctx, req.Storage, role.Connection)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyPolicy := role.hostKeyPolicy(host)
+	client = client.withHostKeyPolicy(hostKeyPolicy)
+
+	// Wait for the host's limits before any work is done for it
+	release, err := client.acquire(ctx, host)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	config, err := getConfig(ctx, req.Storage)
+	config, err := getConfig(ctx, req.Storage, role.Connection)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read configuration: %w", err)
 	}
 
-	// TODO: You can add log messages using the logger object in backend.
-	b.Logger().Debug("getting username and password for host")
+	// Existing accounts only receive a key, otherwise
+	// a new account is created for the lease.
+	creds := &credObject{Username: role.Username}
+	existingAccount := role.Username != ""
+	if !existingAccount {
+		creds.Username, err = generateUsername(role.UsernameTemplate, usernameMetadata{
+			RoleName:    role.Name,
+			DisplayName: req.DisplayName,
+			EntityID:    req.EntityID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	creds, err := getCredentials(ctx, client)
+	switch role.credentialType() {
+	case credentialTypeSSHKey:
+		creds.PrivateKey, creds.PublicKey, err = generateSSHKeyPair(role.KeyType, role.KeyBits, creds.Username)
+	default:
+		passwordPolicy := role.PasswordPolicy
+		if passwordPolicy == "" {
+			passwordPolicy = config.PasswordPolicy
+		}
+		creds.Password, err = b.generatePassword(ctx, passwordPolicy)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error getting credentials: %w", err)
+		return nil, err
 	}
 
-	password, err := b.generatePassword(ctx, config.PasswordPolicy)
+	// Accounts are only created under names that are free on the
+	// host, so a rollback never deletes an account it did not create
+	if !existingAccount {
+		exists, err := client.userExists(ctx, host, creds.Username)
+		if err != nil {
+			return nil, fmt.Errorf("error checking account: %w", err)
+		}
+		if exists {
+			return nil, fmt.Errorf("account %q already exists on %s", creds.Username, host)
+		}
+	}
+
+	// Record the account before creating it so it is rolled back
+	// if it is never returned in a lease.
+	walID, err := framework.PutWAL(ctx, req.Storage, walTypeAccount, &walAccount{
+		Role:            role.Name,
+		Connection:      role.Connection,
+		HostKeyPolicy:   hostKeyPolicy,
+		Host:            host,
+		Username:        creds.Username,
+		PublicKey:       creds.PublicKey,
+		ExistingAccount: existingAccount,
+
+		RevocationStatements: role.RevocationStatements,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	b.Logger().Debug("issuing credentials on host", "host", host, "role", role.Name, "username", creds.Username, "credential_type", role.credentialType())
+
+	// The account expires on the host along with the lease
+	roleTTL, roleMaxTTL := leaseTTLs(role, config)
+	ttl, _, err := framework.CalculateTTL(b.System(), 0, roleTTL, 0, roleMaxTTL, 0, time.Time{})
 	if err != nil {
 		return nil, err
 	}
 
+	if err := getCredentials(ctx, client, host, creds, !existingAccount, role.CreationStatements, time.Now().Add(ttl)); err != nil {
+		// Nothing is left to roll back if the account was never created
+		if !existingAccount {
+			if exists, existsErr := client.userExists(ctx, host, creds.Username); existsErr == nil && !exists {
+				if deleteErr := framework.DeleteWAL(ctx, req.Storage, walID); deleteErr != nil {
+					b.Logger().Warn("error deleting WAL entry", "error", deleteErr)
+				}
+			}
+		}
+		return nil, fmt.Errorf("error getting credentials: %w", err)
+	}
+
+	// Index the account so tidy knows a lease was issued for it
+	if !existingAccount {
+		if err := setIssuedAccount(ctx, req.Storage, &issuedAccount{
+			Role:          role.Name,
+			Connection:    role.Connection,
+			HostKeyPolicy: hostKeyPolicy,
+			Host:          host,
+			Username:      creds.Username,
+		}); err != nil {
+			return nil, fmt.Errorf("error storing issued account: %w", err)
+		}
+	}
+
 	// The response is divided into two objects (1) internal data and (2) data.
 	// If you want to reference any information in your code, you need to
 	// store it in internal data!
-	resp := b.Secret(credObjectType).Response(map[string]interface{}{
-		"username": creds.Username,
-		"password": password,
-	}, map[string]interface{}{
-		"role": role.Name,
-	})
+	// Everything needed to revoke the credentials is stored with the
+	// lease, so it can still be revoked after the role is deleted.
+	internalData := map[string]interface{}{
+		"role":            role.Name,
+		"username":        creds.Username,
+		"host":            host,
+		"connection":      role.Connection,
+		"host_key_policy": hostKeyPolicy,
+	}
+	if len(role.RevocationStatements) > 0 {
+		internalData["revocation_statements"] = role.RevocationStatements
+	}
+	if creds.PublicKey != "" {
+		internalData["public_key"] = creds.PublicKey
+		internalData["existing_account"] = existingAccount
+	}
 
-	if role.TTL > 0 {
-		resp.Secret.TTL = role.TTL
+	resp := b.Secret(credObjectType).Response(creds.toResponseData(), internalData)
+
+	if roleTTL > 0 {
+		resp.Secret.TTL = roleTTL
+	}
+
+	if roleMaxTTL > 0 {
+		resp.Secret.MaxTTL = roleMaxTTL
 	}
 
-	if role.MaxTTL > 0 {
-		resp.Secret.MaxTTL = role.MaxTTL
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		return nil, fmt.Errorf("error deleting WAL entry: %w", err)
 	}
+
 	return resp, nil
 }
 
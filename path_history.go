@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const historyPathSuffix = "/history$"
+
+// pathHistory extends the Vault API with `/host/<role>/history` and
+// `/static-role/<role>/history` endpoints to read a role's recent events.
+func pathHistory(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: hostRolePath + framework.GenericNameRegex("name") + historyPathSuffix,
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the role",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathHistoryRead(hostRolePath),
+				},
+			},
+			HelpSynopsis:    pathHistoryHelpSyn,
+			HelpDescription: pathHistoryHelpDesc,
+		},
+		{
+			Pattern: staticRolePath + framework.GenericNameRegex("name") + historyPathSuffix,
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathHistoryRead(staticRolePath),
+				},
+			},
+			HelpSynopsis:    pathStaticHistoryHelpSyn,
+			HelpDescription: pathStaticHistoryHelpDesc,
+		},
+	}
+}
+
+// pathHistoryRead returns the recent events of a role stored under rolePath, oldest first
+func (b *shellBackend) pathHistoryRead(rolePath string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		name := d.Get("name").(string)
+
+		role, err := req.Storage.Get(ctx, rolePath+name)
+		if err != nil {
+			return nil, err
+		}
+
+		history, err := getRoleHistory(ctx, req.Storage, rolePath, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil && len(history.Events) == 0 {
+			return nil, nil
+		}
+
+		events := make([]map[string]interface{}, 0, len(history.Events))
+		for _, event := range history.ordered() {
+			events = append(events, event.toResponseData())
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"events": events,
+			},
+		}, nil
+	}
+}
+
+const (
+	pathHistoryHelpSyn  = `Read the recent events of a role.`
+	pathHistoryHelpDesc = `
+This path returns the most recent issue, renew and revoke events for
+credentials of the role, oldest first. Each event has the username, host,
+lease ID and entity of the request, and its outcome: success, failure,
+pending for revocations queued for retry, or abandoned for queued
+revocations that were given up on. Issue events have no lease ID, as
+Vault assigns it after the credentials are issued. The history is kept
+after the role is deleted, and continues if a role of the same name is
+created again.
+`
+
+	pathStaticHistoryHelpSyn  = `Read the recent password rotations of a static role.`
+	pathStaticHistoryHelpDesc = `
+This path returns the most recent password rotations of the static role,
+oldest first, and whether each succeeded. The history is kept after the
+static role is deleted.
+`
+)
@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	knownHostsPath        = "known-hosts/"
+	knownHostsStoragePath = "known-hosts/"
+)
+
+// pathKnownHosts extends the Vault API with a `/known-hosts`
+// endpoint to manage the host keys Vault trusts.
+func pathKnownHosts(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: knownHostsPath + `(?P<host>[^/]+)`,
+			Fields: map[string]*framework.FieldSchema{
+				"host": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Host name or host:port. The port defaults to 22.",
+					Required:    true,
+				},
+				"public_key": {
+					Type:        framework.TypeString,
+					Description: "Host public key in authorized_keys format, such as the contents of /etc/ssh/ssh_host_ed25519_key.pub.",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathKnownHostsRead,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathKnownHostsWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathKnownHostsDelete,
+				},
+			},
+			HelpSynopsis:    pathKnownHostsHelpSynopsis,
+			HelpDescription: pathKnownHostsHelpDescription,
+		},
+		{
+			Pattern: knownHostsPath + "?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathKnownHostsList,
+				},
+			},
+			HelpSynopsis:    pathKnownHostsListHelpSynopsis,
+			HelpDescription: pathKnownHostsListHelpDescription,
+		},
+	}
+}
+
+// pathKnownHostsList makes a request to Vault storage to retrieve a list of known hosts
+func (b *shellBackend) pathKnownHostsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, knownHostsStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathKnownHostsRead returns the host key pinned for a host
+func (b *shellBackend) pathKnownHostsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	known, err := getKnownHost(ctx, req.Storage, knownHostName(d.Get("host").(string)))
+	if err != nil {
+		return nil, err
+	}
+
+	if known == nil {
+		return nil, nil
+	}
+
+	key, err := known.publicKey()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing known host key: %w", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"host":        known.Host,
+			"public_key":  known.PublicKey,
+			"key_type":    key.Type(),
+			"fingerprint": ssh.FingerprintSHA256(key),
+		},
+	}, nil
+}
+
+// pathKnownHostsWrite pins a host key, replacing any key
+// pinned for the host before
+func (b *shellBackend) pathKnownHostsWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := knownHostName(d.Get("host").(string))
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(d.Get("public_key").(string)))
+	if err != nil {
+		return logical.ErrorResponse("invalid public_key: %s", err), nil
+	}
+
+	if err := setKnownHost(ctx, req.Storage, name, &knownHost{
+		Host:      name,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}); err != nil {
+		return nil, err
+	}
+
+	// close connections verified against the previous key
+	b.reset()
+
+	return nil, nil
+}
+
+// pathKnownHostsDelete removes the host key pinned for a host
+func (b *shellBackend) pathKnownHostsDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	err := req.Storage.Delete(ctx, knownHostsStoragePath+knownHostName(d.Get("host").(string)))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting known host: %w", err)
+	}
+
+	b.reset()
+
+	return nil, nil
+}
+
+const (
+	pathKnownHostsHelpSynopsis    = `Manages the host keys Vault trusts.`
+	pathKnownHostsHelpDescription = `
+This path allows you to read and write the SSH host key pinned for a host.
+Connections with the strict host_key_policy only reach hosts that have a
+key here. With the tofu policy, the key a host presents on the first
+connection is pinned automatically.
+`
+
+	pathKnownHostsListHelpSynopsis    = `List the known hosts in backend`
+	pathKnownHostsListHelpDescription = `Known hosts will be listed by host:port.`
+)
@@ -0,0 +1,399 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	libraryPath        = "library/"
+	libraryStoragePath = "library/"
+)
+
+// librarySet defines a pool of existing accounts on a host
+// that can be checked out exclusively for a TTL.
+type librarySet struct {
+	Name                      string        `json:"name"`
+	Host                      string        `json:"host"`
+	Connection                string        `json:"connection,omitempty"`
+	HostKeyPolicy             string        `json:"host_key_policy,omitempty"`
+	ServiceAccountNames       []string      `json:"service_account_names"`
+	TTL                       time.Duration `json:"ttl"`
+	MaxTTL                    time.Duration `json:"max_ttl"`
+	DisableCheckInEnforcement bool          `json:"disable_check_in_enforcement"`
+}
+
+// toResponseData returns response data for a library set
+func (l *librarySet) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"name":                         l.Name,
+		"host":                         l.Host,
+		"connection":                   l.Connection,
+		"host_key_policy":              l.HostKeyPolicy,
+		"service_account_names":        l.ServiceAccountNames,
+		"ttl":                          l.TTL.Seconds(),
+		"max_ttl":                      l.MaxTTL.Seconds(),
+		"disable_check_in_enforcement": l.DisableCheckInEnforcement,
+	}
+}
+
+// hasAccount returns whether the account belongs to the set.
+func (l *librarySet) hasAccount(username string) bool {
+	for _, name := range l.ServiceAccountNames {
+		if name == username {
+			return true
+		}
+	}
+	return false
+}
+
+// pathLibrary extends the Vault API with a `/library`
+// endpoint for the backend to manage sets of accounts
+// that can be checked out.
+func pathLibrary(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: libraryPath + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set of accounts",
+					Required:    true,
+				},
+				"host": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Host the accounts exist on",
+					Required:    true,
+				},
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the connection used to reach the host. Defaults to the connection configured at config.",
+					Default:     defaultConnectionName,
+				},
+				"host_key_policy": {
+					Type:        framework.TypeString,
+					Description: "Overrides the connection's host_key_policy for this set: strict, tofu or insecure.",
+				},
+				"service_account_names": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Existing accounts on the host that can be checked out",
+					Required:    true,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Default check-out duration. If not set or set to 0, will use system default.",
+				},
+				"max_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Maximum check-out duration. If not set or set to 0, will use system default.",
+				},
+				"disable_check_in_enforcement": {
+					Type:        framework.TypeBool,
+					Description: "Allow anyone with access to the check-in path to check in any account, not only the entity that checked it out.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathLibraryRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathLibraryDelete,
+				},
+			},
+			ExistenceCheck:  b.pathRoleExistenceCheck,
+			HelpSynopsis:    pathLibraryHelpSynopsis,
+			HelpDescription: pathLibraryHelpDescription,
+		},
+		{
+			Pattern: libraryPath + "?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathLibraryList,
+				},
+			},
+			HelpSynopsis:    pathLibraryListHelpSynopsis,
+			HelpDescription: pathLibraryListHelpDescription,
+		},
+	}
+}
+
+// pathLibraryList makes a request to Vault storage to retrieve a list of library sets
+func (b *shellBackend) pathLibraryList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, libraryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathLibraryRead makes a request to Vault storage to read a library set and return response data
+func (b *shellBackend) pathLibraryRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	set, err := getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: set.toResponseData(),
+	}, nil
+}
+
+// pathLibraryWrite updates a library set. Accounts added to the set
+// have their passwords rotated so only Vault knows them, and accounts
+// removed from the set must be checked in first.
+func (b *shellBackend) pathLibraryWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing set name"), nil
+	}
+
+	b.libraryLock.Lock()
+	defer b.libraryLock.Unlock()
+
+	set, err := getLibrarySet(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	createOperation := (req.Operation == logical.CreateOperation)
+	if set == nil {
+		set = &librarySet{}
+	}
+
+	previousHost := set.Host
+	previousConnection := set.Connection
+	previousAccounts := set.ServiceAccountNames
+
+	set.Name = name
+	if host, ok := d.GetOk("host"); ok {
+		set.Host = host.(string)
+	}
+
+	if connection, ok := d.GetOk("connection"); ok {
+		set.Connection = connection.(string)
+	} else if createOperation {
+		set.Connection = d.Get("connection").(string)
+	}
+
+	if hostKeyPolicy, ok := d.GetOk("host_key_policy"); ok {
+		set.HostKeyPolicy = hostKeyPolicy.(string)
+	}
+
+	if names, ok := d.GetOk("service_account_names"); ok {
+		set.ServiceAccountNames = names.([]string)
+	}
+
+	if ttlRaw, ok := d.GetOk("ttl"); ok {
+		set.TTL = time.Duration(ttlRaw.(int)) * time.Second
+	} else if createOperation {
+		set.TTL = time.Duration(d.Get("ttl").(int)) * time.Second
+	}
+
+	if maxTTLRaw, ok := d.GetOk("max_ttl"); ok {
+		set.MaxTTL = time.Duration(maxTTLRaw.(int)) * time.Second
+	} else if createOperation {
+		set.MaxTTL = time.Duration(d.Get("max_ttl").(int)) * time.Second
+	}
+
+	if disableRaw, ok := d.GetOk("disable_check_in_enforcement"); ok {
+		set.DisableCheckInEnforcement = disableRaw.(bool)
+	}
+
+	if set.Host == "" {
+		return logical.ErrorResponse("missing host"), nil
+	}
+
+	if set.HostKeyPolicy != "" && !validHostKeyPolicies[set.HostKeyPolicy] {
+		return logical.ErrorResponse("host_key_policy must be one of strict, tofu or insecure"), nil
+	}
+
+	if len(set.ServiceAccountNames) == 0 {
+		return logical.ErrorResponse("at least one service account name must be provided"), nil
+	}
+
+	for _, username := range set.ServiceAccountNames {
+		if !validUsername.MatchString(username) {
+			return logical.ErrorResponse("invalid service account name %q", username), nil
+		}
+	}
+
+	if set.MaxTTL != 0 && set.TTL > set.MaxTTL {
+		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
+	}
+
+	if previousHost != "" && (previousHost != set.Host || previousConnection != set.Connection) {
+		return logical.ErrorResponse("host and connection cannot be changed, create a new set instead"), nil
+	}
+
+	if resp, err := validateConnection(ctx, req.Storage, set.Connection); resp != nil || err != nil {
+		return resp, err
+	}
+
+	// An account can only be managed by one set
+	otherSets, err := req.Storage.List(ctx, libraryPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, otherName := range otherSets {
+		if otherName == name {
+			continue
+		}
+		other, err := getLibrarySet(ctx, req.Storage, otherName)
+		if err != nil {
+			return nil, err
+		}
+		if other == nil || other.Host != set.Host {
+			continue
+		}
+		for _, username := range set.ServiceAccountNames {
+			if other.hasAccount(username) {
+				return logical.ErrorResponse("%q is already managed by set %q", username, otherName), nil
+			}
+		}
+	}
+
+	// Accounts removed from the set must not be checked out
+	var removed []string
+	for _, username := range previousAccounts {
+		if set.hasAccount(username) {
+			continue
+		}
+		account, err := getLibraryAccount(ctx, req.Storage, name, username)
+		if err != nil {
+			return nil, err
+		}
+		if account != nil && !account.IsAvailable {
+			return logical.ErrorResponse("%q is checked out and cannot be removed from the set", username), nil
+		}
+		removed = append(removed, username)
+	}
+
+	// Accounts added to the set get a new password before they can be checked out
+	for _, username := range set.ServiceAccountNames {
+		account, err := getLibraryAccount(ctx, req.Storage, name, username)
+		if err != nil {
+			return nil, err
+		}
+		if account != nil {
+			continue
+		}
+		if err := b.rotateLibraryAccount(ctx, req.Storage, set, &libraryAccount{Username: username}); err != nil {
+			return nil, fmt.Errorf("error rotating password for %q: %w", username, err)
+		}
+	}
+
+	for _, username := range removed {
+		if err := req.Storage.Delete(ctx, libraryAccountKey(name, username)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setLibrarySet(ctx, req.Storage, name, set); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// pathLibraryDelete removes a library set once all of its accounts are checked in
+func (b *shellBackend) pathLibraryDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	b.libraryLock.Lock()
+	defer b.libraryLock.Unlock()
+
+	set, err := getLibrarySet(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, nil
+	}
+
+	for _, username := range set.ServiceAccountNames {
+		account, err := getLibraryAccount(ctx, req.Storage, name, username)
+		if err != nil {
+			return nil, err
+		}
+		if account != nil && !account.IsAvailable {
+			return logical.ErrorResponse("%q is checked out, all accounts must be checked in before the set is deleted", username), nil
+		}
+	}
+
+	for _, username := range set.ServiceAccountNames {
+		if err := req.Storage.Delete(ctx, libraryAccountKey(name, username)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, libraryPath+name); err != nil {
+		return nil, fmt.Errorf("error deleting library set: %w", err)
+	}
+
+	return nil, nil
+}
+
+// setLibrarySet adds the library set to the Vault storage API
+func setLibrarySet(ctx context.Context, s logical.Storage, name string, set *librarySet) error {
+	entry, err := logical.StorageEntryJSON(libraryPath+name, set)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for library set")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getLibrarySet gets the library set from the Vault storage API
+func getLibrarySet(ctx context.Context, s logical.Storage, name string) (*librarySet, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing set name")
+	}
+
+	entry, err := s.Get(ctx, libraryPath+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var set librarySet
+
+	if err := entry.DecodeJSON(&set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+const (
+	pathLibraryHelpSynopsis    = `Manages sets of accounts that can be checked out.`
+	pathLibraryHelpDescription = `
+This path allows you to read and write library sets. A library set is a
+pool of existing accounts on a host. Each account can be checked out by
+one caller at a time, and its password is rotated when it is checked in.
+`
+
+	pathLibraryListHelpSynopsis    = `List the existing library sets in backend`
+	pathLibraryListHelpDescription = `Library sets will be listed by the set name.`
+)
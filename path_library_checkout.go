@@ -0,0 +1,315 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/base62"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	libraryManagePath = "library/manage/"
+)
+
+// pathLibraryCheckOut extends the Vault API with endpoints to
+// check accounts in a library set out and back in.
+func pathLibraryCheckOut(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: libraryPath + framework.GenericNameRegex("name") + "/check-out$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set of accounts",
+					Required:    true,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Requested check-out duration. Cannot exceed the set's ttl.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryCheckOut,
+				},
+			},
+			HelpSynopsis:    pathLibraryCheckOutHelpSyn,
+			HelpDescription: pathLibraryCheckOutHelpDesc,
+		},
+		{
+			Pattern: libraryPath + framework.GenericNameRegex("name") + "/check-in$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set of accounts",
+					Required:    true,
+				},
+				"service_account_names": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Accounts to check in. May be omitted if only one account is checked out by the caller.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryCheckIn(false),
+				},
+			},
+			HelpSynopsis:    pathLibraryCheckInHelpSyn,
+			HelpDescription: pathLibraryCheckInHelpDesc,
+		},
+		{
+			Pattern: libraryManagePath + framework.GenericNameRegex("name") + "/check-in$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set of accounts",
+					Required:    true,
+				},
+				"service_account_names": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Accounts to check in. May be omitted if only one account is checked out.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryCheckIn(true),
+				},
+			},
+			HelpSynopsis:    pathLibraryManageCheckInHelpSyn,
+			HelpDescription: pathLibraryManageCheckInHelpDesc,
+		},
+		{
+			Pattern: libraryPath + framework.GenericNameRegex("name") + "/status$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set of accounts",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathLibraryStatus,
+				},
+			},
+			HelpSynopsis:    pathLibraryStatusHelpSyn,
+			HelpDescription: pathLibraryStatusHelpDesc,
+		},
+	}
+}
+
+// pathLibraryCheckOut checks out the first available account in the set.
+func (b *shellBackend) pathLibraryCheckOut(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	setName := d.Get("name").(string)
+
+	b.libraryLock.Lock()
+	defer b.libraryLock.Unlock()
+
+	set, err := getLibrarySet(ctx, req.Storage, setName)
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return logical.ErrorResponse("unknown library set %q", setName), nil
+	}
+
+	ttl := set.TTL
+	if ttlRaw, ok := d.GetOk("ttl"); ok {
+		requested := time.Duration(ttlRaw.(int)) * time.Second
+		if ttl == 0 || requested < ttl {
+			ttl = requested
+		}
+	}
+
+	for _, username := range set.ServiceAccountNames {
+		account, err := getLibraryAccount(ctx, req.Storage, setName, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if account == nil || !account.IsAvailable {
+			continue
+		}
+
+		checkOutID, err := base62.Random(20)
+		if err != nil {
+			return nil, err
+		}
+
+		account.IsAvailable = false
+		account.CheckOutID = checkOutID
+		account.BorrowerEntityID = req.EntityID
+		account.BorrowerClientToken = req.ClientToken
+
+		if err := setLibraryAccount(ctx, req.Storage, setName, account); err != nil {
+			return nil, err
+		}
+
+		resp := b.Secret(libraryAccountType).Response(map[string]interface{}{
+			"service_account_name": account.Username,
+			"password":             account.Password,
+		}, map[string]interface{}{
+			"set":                  setName,
+			"service_account_name": account.Username,
+			"check_out_id":         checkOutID,
+		})
+
+		resp.Secret.TTL = ttl
+		resp.Secret.MaxTTL = set.MaxTTL
+
+		return resp, nil
+	}
+
+	return logical.ErrorResponse("all accounts in set %q are checked out", setName), nil
+}
+
+// pathLibraryCheckIn returns a callback that checks accounts back in.
+// Unless overrideEnforcement is set, callers may only check in accounts
+// they checked out themselves.
+func (b *shellBackend) pathLibraryCheckIn(overrideEnforcement bool) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		setName := d.Get("name").(string)
+
+		b.libraryLock.Lock()
+		defer b.libraryLock.Unlock()
+
+		set, err := getLibrarySet(ctx, req.Storage, setName)
+		if err != nil {
+			return nil, err
+		}
+
+		if set == nil {
+			return logical.ErrorResponse("unknown library set %q", setName), nil
+		}
+
+		enforce := !overrideEnforcement && !set.DisableCheckInEnforcement
+
+		names := d.Get("service_account_names").([]string)
+		if len(names) == 0 {
+			// Find the single account the caller is allowed to check in
+			for _, username := range set.ServiceAccountNames {
+				account, err := getLibraryAccount(ctx, req.Storage, setName, username)
+				if err != nil {
+					return nil, err
+				}
+				if account == nil || account.IsAvailable {
+					continue
+				}
+				if enforce && !checkedOutBy(account, req) {
+					continue
+				}
+				names = append(names, username)
+			}
+
+			if len(names) != 1 {
+				return logical.ErrorResponse("service_account_names must be provided when %d accounts can be checked in", len(names)), nil
+			}
+		}
+
+		for _, username := range names {
+			if !set.hasAccount(username) {
+				return logical.ErrorResponse("%q is not in set %q", username, setName), nil
+			}
+
+			account, err := getLibraryAccount(ctx, req.Storage, setName, username)
+			if err != nil {
+				return nil, err
+			}
+			if account != nil && !account.IsAvailable && enforce && !checkedOutBy(account, req) {
+				return logical.ErrorResponse("%q was checked out by another entity", username), logical.ErrPermissionDenied
+			}
+		}
+
+		var checkedIn []string
+		for _, username := range names {
+			if err := b.checkIn(ctx, req.Storage, setName, username); err != nil {
+				return nil, fmt.Errorf("error checking in %q: %w", username, err)
+			}
+			checkedIn = append(checkedIn, username)
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"check_ins": checkedIn,
+			},
+		}, nil
+	}
+}
+
+// checkedOutBy returns whether the request comes from the entity or
+// token that checked out the account.
+func checkedOutBy(account *libraryAccount, req *logical.Request) bool {
+	if account.BorrowerEntityID != "" {
+		return account.BorrowerEntityID == req.EntityID
+	}
+	return account.BorrowerClientToken == req.ClientToken
+}
+
+// pathLibraryStatus returns whether each account in the set is available.
+func (b *shellBackend) pathLibraryStatus(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	setName := d.Get("name").(string)
+
+	set, err := getLibrarySet(ctx, req.Storage, setName)
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, nil
+	}
+
+	respData := make(map[string]interface{}, len(set.ServiceAccountNames))
+	for _, username := range set.ServiceAccountNames {
+		account, err := getLibraryAccount(ctx, req.Storage, setName, username)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			return nil, errors.New("library account status is missing for " + username)
+		}
+
+		status := map[string]interface{}{
+			"available": account.IsAvailable,
+		}
+		if !account.IsAvailable && account.BorrowerEntityID != "" {
+			status["borrower_entity_id"] = account.BorrowerEntityID
+		}
+		respData[username] = status
+	}
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+const (
+	pathLibraryCheckOutHelpSyn  = `Check out an account from a library set.`
+	pathLibraryCheckOutHelpDesc = `
+This path checks out the first available account in the set for the
+requested TTL. The account is checked in, and its password rotated,
+when the lease is revoked or expires.
+`
+
+	pathLibraryCheckInHelpSyn  = `Check accounts back in to a library set.`
+	pathLibraryCheckInHelpDesc = `
+This path checks in accounts checked out by the caller and rotates their
+passwords so the previous copy no longer works.
+`
+
+	pathLibraryManageCheckInHelpSyn  = `Force accounts back in to a library set.`
+	pathLibraryManageCheckInHelpDesc = `
+This path checks in any account in the set, regardless of who checked it
+out, and rotates its password.
+`
+
+	pathLibraryStatusHelpSyn  = `Check the status of the accounts in a library set.`
+	pathLibraryStatusHelpDesc = `
+This path returns whether each account in the set is available for
+check-out.
+`
+)
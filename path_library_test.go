@@ -0,0 +1,110 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLibrary_CheckOutAndCheckIn(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.CreateOperation, libraryPath+"team", map[string]interface{}{
+		"host":                  server.addr,
+		"service_account_names": "svc1,svc2",
+		"ttl":                   "1h",
+	})
+
+	if n := len(server.commandsContaining("chpasswd")); n != 2 {
+		t.Fatalf("expected both accounts to be rotated when the set is created, got %d", n)
+	}
+
+	first := testRequest(t, b, s, logical.UpdateOperation, libraryPath+"team/check-out", nil)
+	second := testRequest(t, b, s, logical.UpdateOperation, libraryPath+"team/check-out", nil)
+	if first.Data["service_account_name"] == second.Data["service_account_name"] {
+		t.Fatalf("expected different accounts, got %v twice", first.Data["service_account_name"])
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      libraryPath + "team/check-out",
+		Storage:   s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error once every account is checked out, got %#v", resp)
+	}
+
+	// Ending the lease checks the account in with a new password
+	if _, err := testRevoke(t, b, s, first); err != nil {
+		t.Fatal(err)
+	}
+
+	username := first.Data["service_account_name"].(string)
+	rotations := server.commandsContaining("chpasswd")
+	last := rotations[len(rotations)-1]
+	if !strings.HasPrefix(last.Stdin, username+":") {
+		t.Fatalf("expected %s to be rotated on check-in, got %q", username, last.Stdin)
+	}
+
+	account, err := getLibraryAccount(context.Background(), s, "team", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.IsAvailable {
+		t.Error("expected the account to be available after check-in")
+	}
+	if account.Password == first.Data["password"] {
+		t.Error("expected the borrower's password to no longer be valid")
+	}
+	if last.Stdin != username+":"+account.Password+"\n" {
+		t.Errorf("expected the stored password to be set on the host, got %q", last.Stdin)
+	}
+}
+
+func TestLibrary_RotationWALEntry(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	// The host fails part way through setting the password
+	server.handle(func(command, stdin string) (string, uint32) {
+		if strings.Contains(command, "chpasswd") {
+			return "", 1
+		}
+		return "", 0
+	})
+	if _, err := b.HandleRequest(testContext(t), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      libraryPath + "shared",
+		Data: map[string]interface{}{
+			"host":                  server.addr,
+			"service_account_names": "shared1",
+		},
+		Storage: s,
+	}); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("expected the rotation to leave a WAL entry, got %d entries", len(ids))
+	}
+	entry, err := framework.GetWAL(context.Background(), s, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Kind != walTypeLibraryRotation {
+		t.Errorf("expected a %s entry, got %q", walTypeLibraryRotation, entry.Kind)
+	}
+}
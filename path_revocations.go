@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const pendingRevocationsPath = "revocations/pending/"
+
+// pathRevocations extends the Vault API with a `/revocations/pending`
+// endpoint to list the revocations waiting to be retried.
+func pathRevocations(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: pendingRevocationsPath + "?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathPendingRevocationsList,
+				},
+			},
+			HelpSynopsis:    pathPendingRevocationsHelpSyn,
+			HelpDescription: pathPendingRevocationsHelpDesc,
+		},
+		{
+			Pattern: pendingRevocationsPath + framework.GenericNameRegex("id"),
+			Fields: map[string]*framework.FieldSchema{
+				"id": {
+					Type:        framework.TypeString,
+					Description: "ID of the pending revocation",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathPendingRevocationRead,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathPendingRevocationDelete,
+				},
+			},
+			HelpSynopsis:    pathPendingRevocationHelpSyn,
+			HelpDescription: pathPendingRevocationHelpDesc,
+		},
+	}
+}
+
+// pathPendingRevocationsList lists the pending revocations with the lease, host and account of each
+func (b *shellBackend) pathPendingRevocationsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	ids, err := req.Storage.List(ctx, pendingRevocationStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(ids))
+	keyInfo := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		revocation, err := getPendingRevocation(ctx, req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if revocation == nil {
+			continue
+		}
+		keys = append(keys, id)
+		keyInfo[id] = revocation.toResponseData()
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// pathPendingRevocationRead returns a pending revocation
+func (b *shellBackend) pathPendingRevocationRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	revocation, err := getPendingRevocation(ctx, req.Storage, d.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if revocation == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: revocation.toResponseData(),
+	}, nil
+}
+
+// pathPendingRevocationDelete stops retrying a pending revocation
+// and records it as abandoned in the role's history
+func (b *shellBackend) pathPendingRevocationDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.revocationLock.Lock()
+	defer b.revocationLock.Unlock()
+
+	revocation, err := getPendingRevocation(ctx, req.Storage, d.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if revocation == nil {
+		return nil, nil
+	}
+
+	return nil, b.abandonRevocation(ctx, req.Storage, revocation, errors.New("deleted from the queue"))
+}
+
+const (
+	pathPendingRevocationsHelpSyn  = `List revocations waiting to be retried.`
+	pathPendingRevocationsHelpDesc = `
+When credentials cannot be removed from a host at the end of a lease, the
+revocation is queued and retried with exponential backoff, up to once an
+hour, until it succeeds or has failed 48 times. This path lists the
+queued revocations with the lease, host and account of each, how often
+removing it failed, the last error and the time of the next attempt.
+`
+
+	pathPendingRevocationHelpSyn  = `Read or delete a revocation waiting to be retried.`
+	pathPendingRevocationHelpDesc = `
+Reading returns the lease, host and account of the revocation and how
+often removing it failed. Deleting stops retrying it, for example once
+its host is decommissioned. The credentials stay on the host, and the
+revocation is recorded as abandoned in the role's history. An account
+created for the lease is removed by tidy if its host is reachable again.
+`
+)
@@ -9,37 +9,95 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
-// TODO: sets "role" to host path
 const (
 	hostRolePath        = "host/"
-	hostRoleStoragePath = "host/*"
+	hostRoleStoragePath = "host/"
 )
 
 // shellRoleEntry defines the data required
 // for a Vault role to access and call the
 // API endpoints
 type shellRoleEntry struct {
-	Name     string        `json:"name"`
-	Host     string        `json:"host"`
-	Username string        `json:"username,omitempty"`
-	Password string        `json:"password,omitempty"`
-	TTL      time.Duration `json:"ttl"`
-	MaxTTL   time.Duration `json:"max_ttl"`
+	Name             string        `json:"name"`
+	Host             string        `json:"host"`
+	AllowedHosts     []string      `json:"allowed_hosts,omitempty"`
+	Connection       string        `json:"connection,omitempty"`
+	HostKeyPolicy    string        `json:"host_key_policy,omitempty"`
+	Username         string        `json:"username,omitempty"`
+	UsernameTemplate string        `json:"username_template,omitempty"`
+	Password         string        `json:"password,omitempty"`
+	PasswordPolicy   string        `json:"password_policy,omitempty"`
+	CredentialType   string        `json:"credential_type,omitempty"`
+	KeyType          string        `json:"key_type,omitempty"`
+	KeyBits          int           `json:"key_bits,omitempty"`
+	TTL              time.Duration `json:"ttl"`
+	MaxTTL           time.Duration `json:"max_ttl"`
+
+	CreationStatements   []string `json:"creation_statements,omitempty"`
+	RevocationStatements []string `json:"revocation_statements,omitempty"`
+	RenewStatements      []string `json:"renew_statements,omitempty"`
+
+	AllowedPrincipals      []string          `json:"allowed_principals,omitempty"`
+	DefaultPrincipals      []string          `json:"default_principals,omitempty"`
+	AllowedExtensions      []string          `json:"allowed_extensions,omitempty"`
+	DefaultExtensions      map[string]string `json:"default_extensions,omitempty"`
+	AllowedCriticalOptions []string          `json:"allowed_critical_options,omitempty"`
+	DefaultCriticalOptions map[string]string `json:"default_critical_options,omitempty"`
+	AllowUserKeyIDs        bool              `json:"allow_user_key_ids,omitempty"`
 }
 
 // toResponseData returns response data for a role
 func (r *shellRoleEntry) toResponseData() map[string]interface{} {
 	respData := map[string]interface{}{
-		"name": r.Name,
+		"name":            r.Name,
+		"host":            r.Host,
+		"allowed_hosts":   r.AllowedHosts,
+		"connection":      r.Connection,
+		"host_key_policy": r.HostKeyPolicy,
+		"credential_type": r.credentialType(),
 		// "ttl":     r.TTL.Seconds(),
 		// "max_ttl": r.MaxTTL.Seconds(),
 	}
 	if r.Username != "" {
 		respData["username"] = r.Username
 	}
+	if r.UsernameTemplate != "" {
+		respData["username_template"] = r.UsernameTemplate
+	}
+	if r.credentialType() != credentialTypeCertificate {
+		respData["creation_statements"] = r.CreationStatements
+		respData["revocation_statements"] = r.RevocationStatements
+		respData["renew_statements"] = r.RenewStatements
+	}
+	switch r.credentialType() {
+	case credentialTypePassword:
+		respData["password_policy"] = r.PasswordPolicy
+	case credentialTypeSSHKey:
+		respData["key_type"] = r.KeyType
+		if r.KeyType == keyTypeRSA {
+			respData["key_bits"] = r.KeyBits
+		}
+	case credentialTypeCertificate:
+		respData["allowed_principals"] = r.AllowedPrincipals
+		respData["default_principals"] = r.DefaultPrincipals
+		respData["allowed_extensions"] = r.AllowedExtensions
+		respData["default_extensions"] = r.DefaultExtensions
+		respData["allowed_critical_options"] = r.AllowedCriticalOptions
+		respData["default_critical_options"] = r.DefaultCriticalOptions
+		respData["allow_user_key_ids"] = r.AllowUserKeyIDs
+	}
 	return respData
 }
 
+// credentialType returns the type of credential the role issues.
+// Roles written before credential types existed issue passwords.
+func (r *shellRoleEntry) credentialType() string {
+	if r.CredentialType == "" {
+		return credentialTypePassword
+	}
+	return r.CredentialType
+}
+
 // pathRole extends the Vault API with a `/role`
 // endpoint for the backend. You can choose whether
 // or not certain attributes should be displayed,
@@ -57,8 +115,88 @@ func pathRole(b *shellBackend) []*framework.Path {
 				},
 				"host": {
 					Type:        framework.TypeLowerCaseString,
-					Description: "Host to access",
-					Required:    true,
+					Description: "Default host to access when a creds request does not set one",
+				},
+				"allowed_hosts": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Hosts a creds request may select, as glob patterns like *.example.com or CIDR blocks.",
+				},
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the connection used to reach the host. Defaults to the connection configured at config.",
+					Default:     defaultConnectionName,
+				},
+				"host_key_policy": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Overrides the connection's host_key_policy for this role: strict, tofu or insecure.",
+				},
+				"credential_type": {
+					Type:          framework.TypeLowerCaseString,
+					Description:   "Type of credential to issue: password, ssh_key, or certificate for signing with sign/.",
+					Default:       credentialTypePassword,
+					AllowedValues: []interface{}{credentialTypePassword, credentialTypeSSHKey, credentialTypeCertificate},
+				},
+				"key_type": {
+					Type:          framework.TypeLowerCaseString,
+					Description:   "Type of SSH key to generate for ssh_key roles, either ed25519 or rsa.",
+					Default:       keyTypeED25519,
+					AllowedValues: []interface{}{keyTypeED25519, keyTypeRSA},
+				},
+				"key_bits": {
+					Type:        framework.TypeInt,
+					Description: "Number of bits for rsa keys. Defaults to 4096.",
+				},
+				"username": {
+					Type:        framework.TypeString,
+					Description: "Existing account to install keys for with ssh_key roles. If not set, a new account is created per lease.",
+				},
+				"username_template": {
+					Type:        framework.TypeString,
+					Description: "Template for the names of accounts created per lease. Can refer to .RoleName, .DisplayName and .EntityID, and must include a random part. Defaults to v-<role>-<random>.",
+				},
+				"password_policy": {
+					Type:        framework.TypeString,
+					Description: "Password policy to generate passwords with for password roles. Defaults to the connection's password_policy.",
+				},
+				"creation_statements": {
+					Type:        framework.TypeStringSlice,
+					Description: "Commands run on the host to create the account, instead of useradd. Supports {{username}}, {{password}}, {{expiration}} and {{expiration_date}}.",
+				},
+				"revocation_statements": {
+					Type:        framework.TypeStringSlice,
+					Description: "Commands run on the host when the lease is revoked, instead of userdel. Supports {{username}}.",
+				},
+				"renew_statements": {
+					Type:        framework.TypeStringSlice,
+					Description: "Commands run on the host when the lease is renewed. Supports {{username}}, {{expiration}} and {{expiration_date}}.",
+				},
+				"allowed_principals": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Principals certificate roles may sign for. A \"*\" entry allows any principal.",
+				},
+				"default_principals": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Principals to sign for when a request sets none.",
+				},
+				"allowed_extensions": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Extensions a sign request may set. A \"*\" entry allows any extension.",
+				},
+				"default_extensions": {
+					Type:        framework.TypeKVPairs,
+					Description: "Extensions to include when a sign request sets none.",
+				},
+				"allowed_critical_options": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Critical options a sign request may set. A \"*\" entry allows any critical option.",
+				},
+				"default_critical_options": {
+					Type:        framework.TypeKVPairs,
+					Description: "Critical options to include when a sign request sets none.",
+				},
+				"allow_user_key_ids": {
+					Type:        framework.TypeBool,
+					Description: "Let sign requests set the certificate's key_id. By default it is always derived from the role and public key.",
 				},
 				"ttl": {
 					Type:        framework.TypeDurationSecond,
@@ -161,6 +299,148 @@ func (b *shellBackend) pathRolesWrite(ctx context.Context, req *logical.Request,
 		roleEntry.Host = d.Get("host").(string)
 	}
 
+	if allowedHosts, ok := d.GetOk("allowed_hosts"); ok {
+		roleEntry.AllowedHosts = allowedHosts.([]string)
+	}
+
+	if err := validateAllowedHosts(roleEntry.AllowedHosts); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if connection, ok := d.GetOk("connection"); ok {
+		roleEntry.Connection = connection.(string)
+	} else if createOperation {
+		roleEntry.Connection = d.Get("connection").(string)
+	}
+
+	if hostKeyPolicy, ok := d.GetOk("host_key_policy"); ok {
+		roleEntry.HostKeyPolicy = hostKeyPolicy.(string)
+	}
+
+	if roleEntry.HostKeyPolicy != "" && !validHostKeyPolicies[roleEntry.HostKeyPolicy] {
+		return logical.ErrorResponse("host_key_policy must be one of strict, tofu or insecure"), nil
+	}
+
+	if credentialType, ok := d.GetOk("credential_type"); ok {
+		roleEntry.CredentialType = credentialType.(string)
+	} else if createOperation {
+		roleEntry.CredentialType = d.Get("credential_type").(string)
+	}
+
+	if keyType, ok := d.GetOk("key_type"); ok {
+		roleEntry.KeyType = keyType.(string)
+	} else if createOperation {
+		roleEntry.KeyType = d.Get("key_type").(string)
+	}
+
+	if keyBits, ok := d.GetOk("key_bits"); ok {
+		roleEntry.KeyBits = keyBits.(int)
+	}
+
+	if username, ok := d.GetOk("username"); ok {
+		roleEntry.Username = username.(string)
+	}
+
+	if usernameTemplate, ok := d.GetOk("username_template"); ok {
+		roleEntry.UsernameTemplate = usernameTemplate.(string)
+	}
+
+	if roleEntry.UsernameTemplate != "" {
+		if roleEntry.Username != "" {
+			return logical.ErrorResponse("username_template cannot be set with username"), nil
+		}
+		// Render samples so template errors show up now
+		// rather than when credentials are requested
+		if err := checkUsernameTemplate(roleEntry.UsernameTemplate, usernameMetadata{
+			RoleName:    name,
+			DisplayName: "token",
+			EntityID:    "00000000-0000-0000-0000-000000000000",
+		}); err != nil {
+			return logical.ErrorResponse("invalid username_template: %s", err), nil
+		}
+	}
+
+	if passwordPolicy, ok := d.GetOk("password_policy"); ok {
+		roleEntry.PasswordPolicy = passwordPolicy.(string)
+	}
+
+	if roleEntry.PasswordPolicy != "" {
+		if roleEntry.credentialType() != credentialTypePassword {
+			return logical.ErrorResponse("password_policy can only be set for password roles"), nil
+		}
+		// Generate a sample so a missing or unusable policy shows
+		// up now rather than when credentials are requested
+		if _, err := b.generatePassword(ctx, roleEntry.PasswordPolicy); err != nil {
+			return logical.ErrorResponse("invalid password_policy: %s", err), nil
+		}
+	}
+
+	if v, ok := d.GetOk("creation_statements"); ok {
+		roleEntry.CreationStatements = v.([]string)
+	}
+	if v, ok := d.GetOk("revocation_statements"); ok {
+		roleEntry.RevocationStatements = v.([]string)
+	}
+	if v, ok := d.GetOk("renew_statements"); ok {
+		roleEntry.RenewStatements = v.([]string)
+	}
+
+	if v, ok := d.GetOk("allowed_principals"); ok {
+		roleEntry.AllowedPrincipals = v.([]string)
+	}
+	if v, ok := d.GetOk("default_principals"); ok {
+		roleEntry.DefaultPrincipals = v.([]string)
+	}
+	if v, ok := d.GetOk("allowed_extensions"); ok {
+		roleEntry.AllowedExtensions = v.([]string)
+	}
+	if v, ok := d.GetOk("default_extensions"); ok {
+		roleEntry.DefaultExtensions = v.(map[string]string)
+	}
+	if v, ok := d.GetOk("allowed_critical_options"); ok {
+		roleEntry.AllowedCriticalOptions = v.([]string)
+	}
+	if v, ok := d.GetOk("default_critical_options"); ok {
+		roleEntry.DefaultCriticalOptions = v.(map[string]string)
+	}
+	if v, ok := d.GetOk("allow_user_key_ids"); ok {
+		roleEntry.AllowUserKeyIDs = v.(bool)
+	}
+
+	if roleEntry.credentialType() == credentialTypeCertificate {
+		for _, principal := range roleEntry.DefaultPrincipals {
+			if !allowedValue(roleEntry.AllowedPrincipals, principal) {
+				return logical.ErrorResponse("default principal %q is not in allowed_principals", principal), nil
+			}
+		}
+		for _, extension := range sortedKeys(roleEntry.DefaultExtensions) {
+			if !allowedValue(roleEntry.AllowedExtensions, extension) {
+				return logical.ErrorResponse("default extension %q is not in allowed_extensions", extension), nil
+			}
+		}
+		for _, option := range sortedKeys(roleEntry.DefaultCriticalOptions) {
+			if !allowedValue(roleEntry.AllowedCriticalOptions, option) {
+				return logical.ErrorResponse("default critical option %q is not in allowed_critical_options", option), nil
+			}
+		}
+	}
+
+	if roleEntry.credentialType() == credentialTypeSSHKey {
+		if roleEntry.KeyType == keyTypeRSA {
+			if roleEntry.KeyBits == 0 {
+				roleEntry.KeyBits = defaultRSAKeyBits
+			}
+			if !validKeyBits[roleEntry.KeyBits] {
+				return logical.ErrorResponse("key_bits must be one of 2048, 3072 or 4096"), nil
+			}
+		}
+		if roleEntry.Username != "" && !validUsername.MatchString(roleEntry.Username) {
+			return logical.ErrorResponse("invalid username %q", roleEntry.Username), nil
+		}
+	} else if roleEntry.Username != "" {
+		return logical.ErrorResponse("username can only be set for ssh_key roles"), nil
+	}
+
 	if ttlRaw, ok := d.GetOk("ttl"); ok {
 		roleEntry.TTL = time.Duration(ttlRaw.(int)) * time.Second
 	} else if createOperation {
@@ -177,6 +457,28 @@ func (b *shellBackend) pathRolesWrite(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
 	}
 
+	if roleEntry.credentialType() != credentialTypeCertificate {
+		if roleEntry.Host == "" && len(roleEntry.AllowedHosts) == 0 {
+			return logical.ErrorResponse("either host or allowed_hosts must be set"), nil
+		}
+		if resp, err := validateConnection(ctx, req.Storage, roleEntry.Connection); resp != nil || err != nil {
+			return resp, err
+		}
+
+		config, err := getConfig(ctx, req.Storage, roleEntry.Connection)
+		if err != nil {
+			return nil, err
+		}
+		if config.MaxTTL > 0 {
+			if roleEntry.TTL > config.MaxTTL {
+				return logical.ErrorResponse("ttl %s exceeds the max_ttl %s of connection %q", roleEntry.TTL, config.MaxTTL, roleEntry.Connection), nil
+			}
+			if roleEntry.MaxTTL > config.MaxTTL {
+				return logical.ErrorResponse("max_ttl %s exceeds the max_ttl %s of connection %q", roleEntry.MaxTTL, config.MaxTTL, roleEntry.Connection), nil
+			}
+		}
+	}
+
 	if err := setRole(ctx, req.Storage, name, roleEntry); err != nil {
 		return nil, err
 	}
@@ -194,6 +496,35 @@ func (b *shellBackend) pathRolesDelete(ctx context.Context, req *logical.Request
 	return nil, nil
 }
 
+// resolveHost returns the host a creds request targets. Requests
+// without a host use the role's default host, and any other host
+// must match the role's allowed_hosts.
+func (r *shellRoleEntry) resolveHost(requested string) (string, error) {
+	if requested == "" || requested == r.Host {
+		if r.Host == "" {
+			return "", fmt.Errorf("role %q has no default host, a host must be requested", r.Name)
+		}
+		return r.Host, nil
+	}
+
+	if !hostMatches(r.AllowedHosts, requested) {
+		return "", fmt.Errorf("host %q is not allowed by role %q", requested, r.Name)
+	}
+
+	return requested, nil
+}
+
+// hostKeyPolicy returns the host key policy for credentials issued on
+// the host. Hosts other than the role's own host are chosen by the
+// requester, who could point them at a machine they control, so they
+// are only reached once their key has been added to known-hosts.
+func (r *shellRoleEntry) hostKeyPolicy(host string) string {
+	if r.Host == "" || knownHostName(host) != knownHostName(r.Host) {
+		return hostKeyPolicyStrict
+	}
+	return r.HostKeyPolicy
+}
+
 // setRole adds the role to the Vault storage API
 func setRole(ctx context.Context, s logical.Storage, name string, roleEntry *shellRoleEntry) error {
 	entry, err := logical.StorageEntryJSON(hostRolePath+name, roleEntry)
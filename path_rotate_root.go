@@ -0,0 +1,376 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	rotateRootPath = "config/rotate-root"
+)
+
+// pathRotateRoot extends the Vault API with `/config/rotate-root` and
+// `/config/<name>/rotate-root` endpoints that rotate the password of a
+// connection's admin account.
+func pathRotateRoot(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: rotateRootPath,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                    b.pathRotateRootUpdate,
+					ForwardPerformanceStandby:   true,
+					ForwardPerformanceSecondary: true,
+				},
+			},
+			HelpSynopsis:    pathRotateRootHelpSyn,
+			HelpDescription: pathRotateRootHelpDesc,
+		},
+		{
+			Pattern: connectionPath + framework.GenericNameRegex("name") + "/rotate-root$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the connection",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                    b.pathRotateRootUpdate,
+					ForwardPerformanceStandby:   true,
+					ForwardPerformanceSecondary: true,
+				},
+			},
+			HelpSynopsis:    pathRotateRootHelpSyn,
+			HelpDescription: pathRotateRootHelpDesc,
+		},
+	}
+}
+
+// rootRotationHost is a host whose admin account password
+// is rotated along with the connection's.
+type rootRotationHost struct {
+	Host          string `json:"host" mapstructure:"host"`
+	HostKeyPolicy string `json:"host_key_policy,omitempty" mapstructure:"host_key_policy"`
+}
+
+// pathRotateRootUpdate sets a newly generated password for the admin
+// account on every host the connection reaches and stores it in the
+// configuration. If any host rejects the new password, the hosts that
+// accepted it are set back to the current one.
+func (b *shellBackend) pathRotateRootUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	name := connectionName(d)
+
+	config, err := getConfig(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("connection %q is not configured", name)
+	}
+
+	hosts, unlisted, err := b.rootRotationHosts(ctx, req.Storage, name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hosts picked per request from allowed_hosts are not known
+	// until credentials are issued on them, and would be left
+	// with a password only the previous configuration knew
+	if len(unlisted) > 0 {
+		return logical.ErrorResponse("cannot rotate the root password of connection %q while roles %s use allowed_hosts, "+
+			"because their hosts cannot all be rotated", name, strings.Join(unlisted, ", ")), nil
+	}
+
+	skipped, err := skippedRevocationHosts(ctx, req.Storage, name, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := b.getClient(ctx, req.Storage, name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	password, err := b.generatePassword(ctx, config.PasswordPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the rotation so hosts are set back to the stored
+	// password if the new one is set but never stored
+	previous, err := passwordHistory(nil).add(config.Password)
+	if err != nil {
+		return nil, err
+	}
+	entry := &walRootRotation{
+		Connection:   name,
+		Username:     config.Username,
+		Hosts:        hosts,
+		PasswordHash: previous[0],
+		NewPassword:  password,
+	}
+	walID, err := framework.PutWAL(ctx, req.Storage, walTypeRootRotation, entry)
+	if err != nil {
+		return nil, fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	for i, host := range hosts {
+		if err := setRootPassword(ctx, client.withHostKeyPolicy(host.HostKeyPolicy), host.Host, config.Username, password); err != nil {
+			err = fmt.Errorf("error rotating root password on %s: %w", host.Host, err)
+			if restoreErr := b.restoreRootPassword(ctx, req.Storage, config, entry, hosts[:i]); restoreErr != nil {
+				// The WAL entry is rolled back later
+				return nil, errors.Join(err, fmt.Errorf("error restoring root password: %w", restoreErr))
+			}
+			if deleteErr := framework.DeleteWAL(ctx, req.Storage, walID); deleteErr != nil {
+				b.Logger().Warn("error deleting WAL entry", "error", deleteErr)
+			}
+			return nil, err
+		}
+	}
+
+	config.Password = password
+
+	if err := setConfig(ctx, req.Storage, name, config); err != nil {
+		return nil, err
+	}
+
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		return nil, err
+	}
+
+	// reset the client so the next invocation will log in with the new password
+	b.resetClient(name)
+
+	if len(skipped) == 0 {
+		return nil, nil
+	}
+
+	resp := &logical.Response{}
+	resp.AddWarning(fmt.Sprintf("the root password was not rotated on %s, which only pending revocations use; "+
+		"those revocations fail until the password is set there or they are deleted at %s", strings.Join(skipped, ", "), pendingRevocationsPath))
+	return resp, nil
+}
+
+// setRootPassword sets the admin account's password on
+// the host within the connection's limits.
+func setRootPassword(ctx context.Context, c *shellClient, host, username, password string) error {
+	release, err := c.acquire(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return c.setPassword(ctx, host, username, password)
+}
+
+// verifyRootPassword checks that the client's password logs in to
+// the host within the connection's limits.
+func verifyRootPassword(ctx context.Context, c *shellClient, host string) error {
+	release, err := c.acquire(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = c.run(ctx, host, "true", "")
+	return err
+}
+
+// restoreRootPassword sets the admin account on the hosts back to the
+// password in config, which the rotation replaced, logging in with the
+// new password. A host that still accepts the previous password is
+// left as it is.
+func (b *shellBackend) restoreRootPassword(ctx context.Context, s logical.Storage, config *shellConfig, entry *walRootRotation, hosts []rootRotationHost) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	// New clients are used so pooled connections that logged in
+	// before the rotation do not hide which password a host has
+	rotatedConfig := *config
+	rotatedConfig.Password = entry.NewPassword
+	rotated, err := newClient(&rotatedConfig, s)
+	if err != nil {
+		return err
+	}
+	defer rotated.close()
+	rotated.limiter = b.connectionLimiter(entry.Connection, config)
+
+	previous, err := newClient(config, s)
+	if err != nil {
+		return err
+	}
+	defer previous.close()
+	previous.limiter = rotated.limiter
+
+	var errs error
+	for _, host := range hosts {
+		err := setRootPassword(ctx, rotated.withHostKeyPolicy(host.HostKeyPolicy), host.Host, entry.Username, config.Password)
+		if err == nil {
+			continue
+		}
+		if verifyErr := verifyRootPassword(ctx, previous.withHostKeyPolicy(host.HostKeyPolicy), host.Host); verifyErr == nil {
+			continue
+		}
+		errs = errors.Join(errs, fmt.Errorf("%s: %w", host.Host, err))
+	}
+	return errs
+}
+
+// rootRotationHosts returns the connection's URL and every other host
+// its roles, static roles, library sets and outstanding leases refer
+// to. It also returns the roles that pick hosts from allowed_hosts,
+// whose hosts cannot be listed.
+func (b *shellBackend) rootRotationHosts(ctx context.Context, s logical.Storage, name string, config *shellConfig) ([]rootRotationHost, []string, error) {
+	sameConnection := func(connection string) bool {
+		return connection == name || (connection == "" && name == defaultConnectionName)
+	}
+
+	hosts := []rootRotationHost{{Host: config.URL}}
+	seen := map[string]bool{knownHostName(config.URL): true}
+	addHost := func(host, hostKeyPolicy string) {
+		if host == "" || seen[knownHostName(host)] {
+			return
+		}
+		seen[knownHostName(host)] = true
+		hosts = append(hosts, rootRotationHost{Host: host, HostKeyPolicy: hostKeyPolicy})
+	}
+
+	var unlisted []string
+
+	roleNames, err := s.List(ctx, hostRolePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, s, roleName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if role == nil || role.credentialType() == credentialTypeCertificate || !sameConnection(role.Connection) {
+			continue
+		}
+		if len(role.AllowedHosts) > 0 {
+			unlisted = append(unlisted, roleName)
+		}
+		addHost(role.Host, role.HostKeyPolicy)
+	}
+
+	staticRoleNames, err := s.List(ctx, staticRolePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, roleName := range staticRoleNames {
+		role, err := getStaticRole(ctx, s, roleName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if role != nil && sameConnection(role.Connection) {
+			addHost(role.Host, role.HostKeyPolicy)
+		}
+	}
+
+	setNames, err := s.List(ctx, libraryPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, setName := range setNames {
+		set, err := getLibrarySet(ctx, s, setName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if set != nil && sameConnection(set.Connection) {
+			addHost(set.Host, set.HostKeyPolicy)
+		}
+	}
+
+	// Leases may have been issued on hosts the roles no longer
+	// refer to, and still need the admin account to be revoked
+	accountHosts, err := s.List(ctx, issuedAccountStoragePath+name+"/")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, accountHost := range accountHosts {
+		prefix := issuedAccountStoragePath + name + "/" + accountHost
+		usernames, err := s.List(ctx, prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(usernames) == 0 {
+			continue
+		}
+		entry, err := s.Get(ctx, prefix+usernames[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var account issuedAccount
+		if err := entry.DecodeJSON(&account); err != nil {
+			return nil, nil, err
+		}
+		addHost(account.Host, account.HostKeyPolicy)
+	}
+
+	return hosts, unlisted, nil
+}
+
+// skippedRevocationHosts returns the hosts of the connection's pending
+// revocations that are not among the rotated hosts. Those hosts could
+// not be reached when their leases ended, so they are left out of the
+// rotation rather than failing it.
+func skippedRevocationHosts(ctx context.Context, s logical.Storage, name string, hosts []rootRotationHost) ([]string, error) {
+	seen := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		seen[knownHostName(host.Host)] = true
+	}
+
+	ids, err := s.List(ctx, pendingRevocationStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var skipped []string
+	for _, id := range ids {
+		revocation, err := getPendingRevocation(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		if revocation == nil || seen[knownHostName(revocation.Host)] {
+			continue
+		}
+		if revocation.Connection != name && !(revocation.Connection == "" && name == defaultConnectionName) {
+			continue
+		}
+		seen[knownHostName(revocation.Host)] = true
+		skipped = append(skipped, revocation.Host)
+	}
+	return skipped, nil
+}
+
+const pathRotateRootHelpSyn = `
+Request to rotate the root credentials.
+`
+
+const pathRotateRootHelpDesc = `
+This path attempts to rotate the password of the account used to log
+in to the target. The password is changed on the connection's URL and
+on every host its roles, static roles, library sets and leases use, so
+the same password keeps working on all of them. Hosts only pending
+revocations use are skipped and reported in a warning.
+Connections with roles that use allowed_hosts cannot be rotated. If any
+host fails, the others are set back to the current password. After
+rotation, only Vault knows the new password.
+`
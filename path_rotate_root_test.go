@@ -0,0 +1,243 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// adminPasswords returns the passwords the server was asked to
+// set for the admin account, in order.
+func adminPasswords(server *testSSHServer) []string {
+	var passwords []string
+	for _, command := range server.commandsContaining("chpasswd") {
+		if password, ok := strings.CutPrefix(command.Stdin, "admin:"); ok {
+			passwords = append(passwords, strings.TrimSuffix(password, "\n"))
+		}
+	}
+	return passwords
+}
+
+func TestRotateRoot_AllHosts(t *testing.T) {
+	b, s := getTestBackend(t)
+	url := newTestSSHServer(t)
+	other := newTestSSHServer(t)
+	testConfigure(t, b, s, url)
+
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":     other.addr,
+		"username": "app",
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, rotateRootPath, nil)
+
+	config, err := getConfig(context.Background(), s, defaultConnectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Password == "admin-password" {
+		t.Fatal("expected the stored password to change")
+	}
+
+	for _, server := range []*testSSHServer{url, other} {
+		passwords := adminPasswords(server)
+		if len(passwords) != 1 || passwords[0] != config.Password {
+			t.Errorf("expected the new password to be set on %s, got %q", server.addr, passwords)
+		}
+	}
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected the WAL entry to be removed, got %d", len(ids))
+	}
+}
+
+func TestRotateRoot_RestoresOnFailure(t *testing.T) {
+	b, s := getTestBackend(t)
+	url := newTestSSHServer(t)
+	other := newTestSSHServer(t)
+	testConfigure(t, b, s, url)
+
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":     other.addr,
+		"username": "app",
+	})
+
+	other.handle(func(command, stdin string) (string, uint32) {
+		if strings.HasPrefix(stdin, "admin:") {
+			return "", 1
+		}
+		return "", 0
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      rotateRootPath,
+		Storage:   s,
+	})
+	if err == nil {
+		t.Fatalf("expected the rotation to fail, got %#v", resp)
+	}
+
+	passwords := adminPasswords(url)
+	if len(passwords) != 2 || passwords[1] != "admin-password" {
+		t.Fatalf("expected the URL to be set back to the previous password, got %q", passwords)
+	}
+
+	config, err := getConfig(context.Background(), s, defaultConnectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Password != "admin-password" {
+		t.Error("expected the stored password to be kept")
+	}
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected the WAL entry to be removed once restored, got %d", len(ids))
+	}
+}
+
+func TestRotateRoot_AllowedHosts(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"allowed_hosts": "*.example.com",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      rotateRootPath,
+		Storage:   s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error response, got %#v", resp)
+	}
+	if passwords := adminPasswords(server); len(passwords) != 0 {
+		t.Errorf("expected no password to be set, got %q", passwords)
+	}
+}
+
+func TestRotateRoot_WALRollback(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	previous, err := passwordHistory(nil).add("admin-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A rotation that set the new password but never stored it
+	_, err = framework.PutWAL(context.Background(), s, walTypeRootRotation, &walRootRotation{
+		Connection:   defaultConnectionName,
+		Username:     "admin",
+		Hosts:        []rootRotationHost{{Host: server.addr}},
+		PasswordHash: previous[0],
+		NewPassword:  "lost-password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRollback(t, b, s)
+
+	passwords := adminPasswords(server)
+	if len(passwords) != 1 || passwords[0] != "admin-password" {
+		t.Fatalf("expected the stored password to be restored, got %q", passwords)
+	}
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected the WAL entry to be removed, got %d", len(ids))
+	}
+}
+
+func TestRotateRoot_WALEntry(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	// Read the WAL entry while the new password is being set
+	var entries []string
+	server.handle(func(command, stdin string) (string, uint32) {
+		ids, err := framework.ListWAL(context.Background(), s)
+		if err != nil {
+			t.Error(err)
+		}
+		for _, id := range ids {
+			entry, err := s.Get(context.Background(), framework.WALPrefix+id)
+			if err != nil || entry == nil {
+				t.Errorf("reading WAL entry %s: %v", id, err)
+				continue
+			}
+			entries = append(entries, string(entry.Value))
+		}
+		return "", 0
+	})
+
+	testRequest(t, b, s, logical.UpdateOperation, rotateRootPath, nil)
+
+	if len(entries) != 1 {
+		t.Fatalf("expected a WAL entry during the rotation, got %d", len(entries))
+	}
+	if strings.Contains(entries[0], "admin-password") {
+		t.Error("expected the WAL entry not to hold the previous password")
+	}
+
+	var sealWrapped bool
+	for _, path := range b.PathsSpecial.SealWrapStorage {
+		sealWrapped = sealWrapped || path == framework.WALPrefix
+	}
+	if !sealWrapped {
+		t.Error("expected WAL entries, which hold the new password, to be seal wrapped")
+	}
+}
+
+func TestRotateRoot_SkipsPendingRevocationHosts(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	// A host that could not be reached to revoke a lease
+	unreachable := newTestSSHServer(t)
+	unreachable.stop()
+	if err := setPendingRevocation(context.Background(), s, &pendingRevocation{
+		ID:         "pending",
+		Connection: defaultConnectionName,
+		Host:       unreachable.addr,
+		Username:   "v-web-gone",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, rotateRootPath, nil)
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], unreachable.addr) {
+		t.Fatalf("expected a warning about the skipped host, got %#v", resp)
+	}
+
+	config, err := getConfig(context.Background(), s, defaultConnectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passwords := adminPasswords(server); len(passwords) != 1 || passwords[0] != config.Password {
+		t.Errorf("expected the new password to be set and stored, got %q", passwords)
+	}
+}
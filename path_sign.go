@@ -0,0 +1,265 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	signPath = "sign/"
+
+	// certificateClockSkew backdates certificates so hosts
+	// with a slightly slow clock accept them straight away.
+	certificateClockSkew = 30 * time.Second
+)
+
+// pathSign extends the Vault API with a `/sign` endpoint
+// that signs a caller's public key with the backend's CA.
+func pathSign(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: signPath + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the role",
+					Required:    true,
+				},
+				"public_key": {
+					Type:        framework.TypeString,
+					Description: "SSH public key to sign, in authorized_keys format.",
+					Required:    true,
+				},
+				"valid_principals": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Principals to include in the certificate. Defaults to the role's default_principals.",
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Requested certificate lifetime. Cannot exceed the role's max_ttl.",
+				},
+				"key_id": {
+					Type:        framework.TypeString,
+					Description: "Key ID for the certificate. Only allowed when the role sets allow_user_key_ids, otherwise it is derived from the role and key fingerprint.",
+				},
+				"extensions": {
+					Type:        framework.TypeMap,
+					Description: "Extensions to include in the certificate. Defaults to the role's default_extensions.",
+				},
+				"critical_options": {
+					Type:        framework.TypeMap,
+					Description: "Critical options to include in the certificate. Defaults to the role's default_critical_options.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathSignWrite,
+				},
+			},
+			HelpSynopsis:    pathSignHelpSyn,
+			HelpDescription: pathSignHelpDesc,
+		},
+	}
+}
+
+// pathSignWrite signs the caller's public key with the principals,
+// extensions, critical options and TTL allowed by the role.
+func (b *shellBackend) pathSignWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving role: %w", err)
+	}
+
+	if role == nil {
+		return logical.ErrorResponse("unknown role %q", roleName), nil
+	}
+
+	if role.credentialType() != credentialTypeCertificate {
+		return logical.ErrorResponse("role %q does not issue certificates", roleName), nil
+	}
+
+	ca, err := getCA(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if ca == nil {
+		return logical.ErrorResponse("no CA key pair is configured"), nil
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(d.Get("public_key").(string)))
+	if err != nil {
+		return logical.ErrorResponse("error parsing public_key: %s", err), nil
+	}
+
+	principals := d.Get("valid_principals").([]string)
+	if len(principals) == 0 {
+		principals = role.DefaultPrincipals
+	}
+	if len(principals) == 0 {
+		return logical.ErrorResponse("valid_principals must be set when the role has no default_principals"), nil
+	}
+	for _, principal := range principals {
+		if !allowedValue(role.AllowedPrincipals, principal) {
+			return logical.ErrorResponse("principal %q is not allowed by role %q", principal, roleName), nil
+		}
+	}
+
+	extensions, err := certificateOptions(d, "extensions", role.DefaultExtensions, role.AllowedExtensions)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	criticalOptions, err := certificateOptions(d, "critical_options", role.DefaultCriticalOptions, role.AllowedCriticalOptions)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ttl, err := b.certificateTTL(d, role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// The key ID is logged by sshd, so callers only choose it
+	// when the role trusts them to
+	keyID := fmt.Sprintf("vault-%s-%s", roleName, ssh.FingerprintSHA256(publicKey))
+	if requested := d.Get("key_id").(string); requested != "" {
+		if !role.AllowUserKeyIDs {
+			return logical.ErrorResponse("role %q does not allow setting key_id", roleName), nil
+		}
+		keyID = requested
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	certificate := &ssh.Certificate{
+		Key:             publicKey,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-certificateClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: criticalOptions,
+			Extensions:      extensions,
+		},
+	}
+
+	signer, err := ca.signer()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA private key: %w", err)
+	}
+
+	if err := certificate.SignCert(rand.Reader, signer); err != nil {
+		return nil, fmt.Errorf("error signing certificate: %w", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": fmt.Sprintf("%016x", certificate.Serial),
+			"signed_key":    strings.TrimSpace(string(ssh.MarshalAuthorizedKey(certificate))),
+		},
+	}, nil
+}
+
+// certificateTTL returns the requested TTL bounded by the role's
+// max_ttl, falling back to the role's ttl and the mount defaults.
+func (b *shellBackend) certificateTTL(d *framework.FieldData, role *shellRoleEntry) (time.Duration, error) {
+	ttl := role.TTL
+	if ttl == 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+
+	if ttlRaw, ok := d.GetOk("ttl"); ok {
+		ttl = time.Duration(ttlRaw.(int)) * time.Second
+	}
+
+	maxTTL := role.MaxTTL
+	if maxTTL == 0 {
+		maxTTL = b.System().MaxLeaseTTL()
+	}
+
+	if maxTTL > 0 && ttl > maxTTL {
+		return 0, fmt.Errorf("ttl %s cannot be greater than max_ttl %s", ttl, maxTTL)
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("ttl must be greater than zero")
+	}
+
+	return ttl, nil
+}
+
+// certificateOptions returns the requested extensions or critical
+// options, or the role's defaults if none were requested. Every
+// requested option must be allowed by the role.
+func certificateOptions(d *framework.FieldData, field string, defaults map[string]string, allowed []string) (map[string]string, error) {
+	requested := d.Get(field).(map[string]interface{})
+	if len(requested) == 0 {
+		options := make(map[string]string, len(defaults))
+		for k, v := range defaults {
+			options[k] = v
+		}
+		return options, nil
+	}
+
+	options := make(map[string]string, len(requested))
+	for k, v := range requested {
+		if !allowedValue(allowed, k) {
+			return nil, fmt.Errorf("%s %q is not allowed by the role", field, k)
+		}
+		value, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s %q must have a string value", field, k)
+		}
+		options[k] = value
+	}
+	return options, nil
+}
+
+// sortedKeys returns the keys of the options in sorted order.
+func sortedKeys(options map[string]string) []string {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// allowedValue returns whether value is in the allowed list.
+// A "*" entry allows any value.
+func allowedValue(allowed []string, value string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == value {
+			return true
+		}
+	}
+	return false
+}
+
+const pathSignHelpSyn = `
+Request to sign an SSH public key using a certain role.
+`
+
+const pathSignHelpDesc = `
+This path signs a public key with the backend's CA and returns a short
+lived SSH user certificate. The role controls which principals,
+extensions and critical options the certificate may carry, and its TTL.
+`
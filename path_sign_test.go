@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+// testPublicKey returns a new public key in authorized_keys format.
+func testPublicKey(t *testing.T) string {
+	t.Helper()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey)))
+}
+
+// parseSignedKey parses the certificate in a sign response.
+func parseSignedKey(t *testing.T, resp *logical.Response) *ssh.Certificate {
+	t.Helper()
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Data["signed_key"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.(*ssh.Certificate)
+}
+
+func TestSign_KeyID(t *testing.T) {
+	b, s := getTestBackend(t)
+	testRequest(t, b, s, logical.UpdateOperation, caPath, nil)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"credential_type":    credentialTypeCertificate,
+		"allowed_principals": "deploy",
+		"default_principals": "deploy",
+		"ttl":                "30m",
+	})
+
+	publicKey := testPublicKey(t)
+	resp := testRequest(t, b, s, logical.UpdateOperation, signPath+"web", map[string]interface{}{
+		"public_key": publicKey,
+	})
+	certificate := parseSignedKey(t, resp)
+	if !strings.HasPrefix(certificate.KeyId, "vault-web-SHA256:") {
+		t.Errorf("expected a key ID derived from the role and key, got %q", certificate.KeyId)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      signPath + "web",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"public_key": publicKey,
+			"key_id":     "someone-else",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected key_id to be rejected, got %#v", resp)
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"allow_user_key_ids": true,
+	})
+	resp = testRequest(t, b, s, logical.UpdateOperation, signPath+"web", map[string]interface{}{
+		"public_key": publicKey,
+		"key_id":     "deploy-laptop",
+	})
+	if certificate := parseSignedKey(t, resp); certificate.KeyId != "deploy-laptop" {
+		t.Errorf("expected the requested key ID, got %q", certificate.KeyId)
+	}
+}
+
+func TestRole_DefaultCertificateOptionsMustBeAllowed(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	for name, data := range map[string]map[string]interface{}{
+		"extension": {
+			"allowed_extensions": "permit-pty",
+			"default_extensions": "permit-port-forwarding=",
+		},
+		"critical option": {
+			"allowed_critical_options": "source-address",
+			"default_critical_options": "force-command=/bin/true",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data["credential_type"] = credentialTypeCertificate
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      hostRolePath + "web",
+				Storage:   s,
+				Data:      data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil || !resp.IsError() {
+				t.Fatalf("expected the role to be rejected, got %#v", resp)
+			}
+		})
+	}
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"credential_type":    credentialTypeCertificate,
+		"allowed_extensions": "permit-pty",
+		"default_extensions": "permit-pty=",
+	})
+}
@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	staticCredsPath = "static-creds/"
+)
+
+// pathStaticCredentials extends the Vault API with a `/static-creds`
+// endpoint to read the current password of a static role.
+func pathStaticCredentials(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: staticCredsPath + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticCredentialsRead,
+				},
+			},
+			HelpSynopsis:    pathStaticCredentialsHelpSyn,
+			HelpDescription: pathStaticCredentialsHelpDesc,
+		},
+	}
+}
+
+// pathStaticCredentialsRead returns the current password of
+// the account managed by a static role.
+func (b *shellBackend) pathStaticCredentialsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	roleEntry, err := getStaticRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleEntry == nil {
+		return logical.ErrorResponse("unknown static role %q", name), nil
+	}
+
+	ttl := time.Until(roleEntry.nextRotation())
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"username":            roleEntry.Username,
+			"password":            roleEntry.Password,
+			"host":                roleEntry.Host,
+			"last_vault_rotation": roleEntry.LastVaultRotation,
+			"rotation_period":     roleEntry.RotationPeriod.Seconds(),
+			"ttl":                 int64(ttl.Seconds()),
+		},
+	}, nil
+}
+
+const pathStaticCredentialsHelpSyn = `
+Request the current password of a static role.
+`
+
+const pathStaticCredentialsHelpDesc = `
+This path reads the password Vault last set for the account
+managed by a static role, and how long until it is rotated.
+`
@@ -0,0 +1,315 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	staticRolePath        = "static-role/"
+	staticRoleStoragePath = "static-role/"
+
+	defaultRotationPeriod = 24 * time.Hour
+	minRotationPeriod     = 5 * time.Second
+)
+
+// staticRoleEntry maps a Vault role to an existing
+// account on a host. Vault rotates the account's
+// password every rotation period.
+type staticRoleEntry struct {
+	Name               string        `json:"name"`
+	Host               string        `json:"host"`
+	Connection         string        `json:"connection,omitempty"`
+	HostKeyPolicy      string        `json:"host_key_policy,omitempty"`
+	Username           string        `json:"username"`
+	Password           string        `json:"password"`
+	RotationStatements []string      `json:"rotation_statements,omitempty"`
+	RotationPeriod     time.Duration `json:"rotation_period"`
+	LastVaultRotation  time.Time     `json:"last_vault_rotation"`
+
+	// PasswordHistory holds hashes of the account's
+	// previous passwords, which are never reused
+	PasswordHistory passwordHistory `json:"password_history,omitempty"`
+}
+
+// toResponseData returns response data for a static role
+func (r *staticRoleEntry) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"name":            r.Name,
+		"host":            r.Host,
+		"connection":      r.Connection,
+		"host_key_policy": r.HostKeyPolicy,
+		"username":        r.Username,
+		"rotation_period": r.RotationPeriod.Seconds(),
+	}
+	if len(r.RotationStatements) > 0 {
+		respData["rotation_statements"] = r.RotationStatements
+	}
+	if !r.LastVaultRotation.IsZero() {
+		respData["last_vault_rotation"] = r.LastVaultRotation
+	}
+	return respData
+}
+
+// nextRotation returns the time the password is next due for rotation.
+func (r *staticRoleEntry) nextRotation() time.Time {
+	return r.LastVaultRotation.Add(r.RotationPeriod)
+}
+
+// pathStaticRole extends the Vault API with a `/static-role`
+// endpoint for the backend to manage existing accounts.
+func pathStaticRole(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: staticRolePath + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+				"host": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Host the account exists on",
+					Required:    true,
+				},
+				"connection": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the connection used to reach the host. Defaults to the connection configured at config.",
+					Default:     defaultConnectionName,
+				},
+				"host_key_policy": {
+					Type:        framework.TypeString,
+					Description: "Overrides the connection's host_key_policy for this static role: strict, tofu or insecure.",
+				},
+				"username": {
+					Type:        framework.TypeString,
+					Description: "Existing account on the host whose password Vault rotates",
+					Required:    true,
+				},
+				"rotation_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Period for automatic password rotation. Defaults to 24 hours.",
+				},
+				"rotation_statements": {
+					Type:        framework.TypeStringSlice,
+					Description: "Commands run on the host to set a new password, instead of chpasswd. Supports {{username}}, {{password}}, {{expiration}} and {{expiration_date}}.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesDelete,
+				},
+			},
+			ExistenceCheck:  b.pathRoleExistenceCheck,
+			HelpSynopsis:    pathStaticRoleHelpSynopsis,
+			HelpDescription: pathStaticRoleHelpDescription,
+		},
+		{
+			Pattern: staticRolePath + "?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesList,
+				},
+			},
+			HelpSynopsis:    pathStaticRoleListHelpSynopsis,
+			HelpDescription: pathStaticRoleListHelpDescription,
+		},
+	}
+}
+
+// pathStaticRolesList makes a request to Vault storage to retrieve a list of static roles for the backend
+func (b *shellBackend) pathStaticRolesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, staticRolePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathStaticRolesRead makes a request to Vault storage to read a static role and return response data
+func (b *shellBackend) pathStaticRolesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entry, err := getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: entry.toResponseData(),
+	}, nil
+}
+
+// pathStaticRolesWrite updates a static role and rotates the account's
+// password when the role is created or its account changes
+func (b *shellBackend) pathStaticRolesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing role name"), nil
+	}
+
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	roleEntry, err := getStaticRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleEntry == nil {
+		roleEntry = &staticRoleEntry{}
+	}
+
+	createOperation := (req.Operation == logical.CreateOperation)
+	accountChanged := createOperation
+
+	roleEntry.Name = name
+	if host, ok := d.GetOk("host"); ok {
+		accountChanged = accountChanged || roleEntry.Host != host.(string)
+		roleEntry.Host = host.(string)
+	}
+
+	if connection, ok := d.GetOk("connection"); ok {
+		accountChanged = accountChanged || roleEntry.Connection != connection.(string)
+		roleEntry.Connection = connection.(string)
+	} else if createOperation {
+		roleEntry.Connection = d.Get("connection").(string)
+	}
+
+	if hostKeyPolicy, ok := d.GetOk("host_key_policy"); ok {
+		roleEntry.HostKeyPolicy = hostKeyPolicy.(string)
+	}
+
+	if username, ok := d.GetOk("username"); ok {
+		accountChanged = accountChanged || roleEntry.Username != username.(string)
+		roleEntry.Username = username.(string)
+	}
+
+	if statements, ok := d.GetOk("rotation_statements"); ok {
+		roleEntry.RotationStatements = statements.([]string)
+	}
+
+	if rotationPeriodRaw, ok := d.GetOk("rotation_period"); ok {
+		roleEntry.RotationPeriod = time.Duration(rotationPeriodRaw.(int)) * time.Second
+	} else if createOperation {
+		roleEntry.RotationPeriod = defaultRotationPeriod
+	}
+
+	if roleEntry.Host == "" {
+		return logical.ErrorResponse("missing host"), nil
+	}
+
+	if roleEntry.HostKeyPolicy != "" && !validHostKeyPolicies[roleEntry.HostKeyPolicy] {
+		return logical.ErrorResponse("host_key_policy must be one of strict, tofu or insecure"), nil
+	}
+
+	if !validUsername.MatchString(roleEntry.Username) {
+		return logical.ErrorResponse("invalid username %q", roleEntry.Username), nil
+	}
+
+	if roleEntry.RotationPeriod < minRotationPeriod {
+		return logical.ErrorResponse("rotation_period must be at least %s", minRotationPeriod), nil
+	}
+
+	if resp, err := validateConnection(ctx, req.Storage, roleEntry.Connection); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if accountChanged {
+		// The history belongs to the previous account
+		roleEntry.PasswordHistory = nil
+		if err := b.rotateStaticRole(ctx, req.Storage, roleEntry); err != nil {
+			return nil, fmt.Errorf("error rotating password for %q: %w", roleEntry.Username, err)
+		}
+		return nil, nil
+	}
+
+	if err := setStaticRole(ctx, req.Storage, name, roleEntry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// pathStaticRolesDelete makes a request to Vault storage to delete a static role
+func (b *shellBackend) pathStaticRolesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	err := req.Storage.Delete(ctx, staticRolePath+d.Get("name").(string))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting static role: %w", err)
+	}
+
+	return nil, nil
+}
+
+// setStaticRole adds the static role to the Vault storage API
+func setStaticRole(ctx context.Context, s logical.Storage, name string, roleEntry *staticRoleEntry) error {
+	entry, err := logical.StorageEntryJSON(staticRolePath+name, roleEntry)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for static role")
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getStaticRole gets the static role from the Vault storage API
+func getStaticRole(ctx context.Context, s logical.Storage, name string) (*staticRoleEntry, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing role name")
+	}
+
+	entry, err := s.Get(ctx, staticRolePath+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role staticRoleEntry
+
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+const (
+	pathStaticRoleHelpSynopsis    = `Manages static roles for existing accounts on hosts.`
+	pathStaticRoleHelpDescription = `
+This path allows you to read and write static roles. A static role maps
+to an existing account on a host. Vault rotates the account's password
+every rotation_period.
+`
+
+	pathStaticRoleListHelpSynopsis    = `List the existing static roles in backend`
+	pathStaticRoleListHelpDescription = `Static roles will be listed by the role name.`
+)
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestStaticRole_RotatesPassword(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":            server.addr,
+		"username":        "app",
+		"rotation_period": "24h",
+	})
+
+	rotations := server.commandsContaining("chpasswd")
+	if len(rotations) != 1 {
+		t.Fatalf("expected the password to be set when the role is created, got %#v", server.commands())
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, staticCredsPath+"app", nil)
+	password := resp.Data["password"].(string)
+	if rotations[0].Stdin != "app:"+password+"\n" {
+		t.Errorf("expected the stored password to be set on the host, got %q", rotations[0].Stdin)
+	}
+
+	// Rotations are skipped until the period has elapsed
+	if err := b.rotateExpiredStaticRoles(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.commandsContaining("chpasswd")); n != 1 {
+		t.Fatalf("expected no rotation before the period elapsed, got %d", n)
+	}
+
+	role, err := getStaticRole(context.Background(), s, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role.LastVaultRotation = time.Now().Add(-25 * time.Hour)
+	if err := setStaticRole(context.Background(), s, "app", role); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.rotateExpiredStaticRoles(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	rotations = server.commandsContaining("chpasswd")
+	if len(rotations) != 2 {
+		t.Fatalf("expected the password to be rotated, got %#v", server.commands())
+	}
+
+	resp = testRequest(t, b, s, logical.ReadOperation, staticCredsPath+"app", nil)
+	rotated := resp.Data["password"].(string)
+	if rotated == password {
+		t.Error("expected a new password after rotation")
+	}
+	if rotations[1].Stdin != "app:"+rotated+"\n" {
+		t.Errorf("expected the rotated password to be set on the host, got %q", rotations[1].Stdin)
+	}
+}
+
+func TestStaticRole_RotationFailureKeepsPassword(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":     server.addr,
+		"username": "app",
+	})
+	password := testRequest(t, b, s, logical.ReadOperation, staticCredsPath+"app", nil).Data["password"]
+
+	role, err := getStaticRole(context.Background(), s, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role.LastVaultRotation = time.Time{}
+	if err := setStaticRole(context.Background(), s, "app", role); err != nil {
+		t.Fatal(err)
+	}
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		return "", 1
+	})
+	if err := b.rotateExpiredStaticRoles(context.Background(), s); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+
+	resp := testRequest(t, b, s, logical.ReadOperation, staticCredsPath+"app", nil)
+	if resp.Data["password"] != password {
+		t.Error("expected the stored password to be kept when rotation fails")
+	}
+}
@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	tidyPath       = "tidy"
+	tidyConfigPath = "config/tidy"
+)
+
+// pathTidy extends the Vault API with a `/tidy` endpoint to remove
+// orphaned accounts, and a `/config/tidy` endpoint to run it periodically.
+func pathTidy(b *shellBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: tidyPath + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Only report orphaned accounts instead of removing them. Defaults to true.",
+					Default:     true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTidyUpdate,
+				},
+			},
+			HelpSynopsis:    pathTidyHelpSyn,
+			HelpDescription: pathTidyHelpDesc,
+		},
+		{
+			Pattern: tidyConfigPath,
+			Fields: map[string]*framework.FieldSchema{
+				"interval": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How often to tidy automatically. Set to 0 to disable automatic tidying.",
+				},
+				"username_prefix": {
+					Type:        framework.TypeString,
+					Description: "Prefix of the accounts created by the mount. Defaults to v-.",
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Only log orphaned accounts found by automatic tidying instead of removing them. Defaults to true.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathTidyConfigRead,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTidyConfigWrite,
+				},
+			},
+			HelpSynopsis:    pathTidyConfigHelpSyn,
+			HelpDescription: pathTidyConfigHelpDesc,
+		},
+	}
+}
+
+// pathTidyUpdate removes or reports orphaned accounts on the hosts known to the mount
+func (b *shellBackend) pathTidyUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if !b.tidyLock.TryLock() {
+		return logical.ErrorResponse("a tidy operation is already running"), nil
+	}
+	defer b.tidyLock.Unlock()
+
+	result, err := b.tidyAccounts(ctx, req.Storage, config, d.Get("dry_run").(bool))
+	if err != nil {
+		return nil, err
+	}
+	b.lastTidy = time.Now()
+
+	return &logical.Response{
+		Data: result.toResponseData(),
+	}, nil
+}
+
+// pathTidyConfigRead returns the tidy configuration
+func (b *shellBackend) pathTidyConfigRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"interval":        config.Interval.Seconds(),
+			"username_prefix": config.UsernamePrefix,
+			"dry_run":         config.DryRun,
+		},
+	}, nil
+}
+
+// pathTidyConfigWrite updates the tidy configuration
+func (b *shellBackend) pathTidyConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := getTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if intervalRaw, ok := d.GetOk("interval"); ok {
+		config.Interval = time.Duration(intervalRaw.(int)) * time.Second
+	}
+
+	if prefix, ok := d.GetOk("username_prefix"); ok {
+		config.UsernamePrefix = prefix.(string)
+	}
+
+	if dryRun, ok := d.GetOk("dry_run"); ok {
+		config.DryRun = dryRun.(bool)
+	}
+
+	if config.Interval < 0 {
+		return logical.ErrorResponse("interval cannot be negative"), nil
+	}
+
+	// An empty prefix would match every account on the host
+	if config.UsernamePrefix == "" {
+		return logical.ErrorResponse("username_prefix cannot be empty"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(tidyConfigStoragePath, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const (
+	pathTidyHelpSyn  = `Remove accounts that no lease was issued for.`
+	pathTidyHelpDesc = `
+This path checks the hosts of the mount's roles, and the hosts credentials
+were issued on, for accounts that start with the tidy username_prefix but
+do not belong to an outstanding lease, such as accounts left behind by a
+failed revocation or a restore. These accounts are only reported unless
+dry_run is set to false.
+`
+
+	pathTidyConfigHelpSyn  = `Configure automatic tidying of orphaned accounts.`
+	pathTidyConfigHelpDesc = `
+This path configures how often orphaned accounts are tidied automatically,
+and the username prefix of the accounts the mount creates.
+`
+)
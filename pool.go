@@ -0,0 +1,182 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// defaultMaxConnectionsPerHost bounds the number of SSH
+	// connections open to a single host at once.
+	defaultMaxConnectionsPerHost = 4
+
+	// defaultMaxIdleTime is how long an unused connection
+	// stays in the pool before it is closed.
+	defaultMaxIdleTime = 5 * time.Minute
+
+	// connectionRequestTimeout bounds how long a health check or a
+	// new session waits for the server to answer on a connection.
+	connectionRequestTimeout = 10 * time.Second
+)
+
+// pooledConn is an idle SSH connection and the time it was last used.
+type pooledConn struct {
+	client   *ssh.Client
+	lastUsed time.Time
+}
+
+// hostPool holds the idle connections to one host. slots bounds
+// the number of connections open to the host, idle or in use.
+// Connections made under different host key policies are kept
+// in separate pools.
+type hostPool struct {
+	slots chan struct{}
+	idle  []*pooledConn
+}
+
+// connPool keeps SSH connections open between remote commands so
+// requests for the same host in quick succession share a connection.
+type connPool struct {
+	mu          sync.Mutex
+	hosts       map[string]*hostPool
+	maxPerHost  int
+	maxIdleTime time.Duration
+	closed      bool
+}
+
+func newConnPool() *connPool {
+	return &connPool{
+		hosts:       make(map[string]*hostPool),
+		maxPerHost:  defaultMaxConnectionsPerHost,
+		maxIdleTime: defaultMaxIdleTime,
+	}
+}
+
+// host returns the pool for a key, creating it if needed.
+// Callers must hold the lock.
+func (p *connPool) host(key string) *hostPool {
+	hp, ok := p.hosts[key]
+	if !ok {
+		hp = &hostPool{slots: make(chan struct{}, p.maxPerHost)}
+		p.hosts[key] = hp
+	}
+	return hp
+}
+
+// get returns a healthy connection from the pool for the key, reusing an
+// idle one if possible and calling dial otherwise. It waits for a free
+// slot when the host already has the maximum number of connections
+// open, until the context is done.
+func (p *connPool) get(ctx context.Context, key string, dial func(context.Context) (*ssh.Client, error)) (*ssh.Client, error) {
+	p.mu.Lock()
+	hp := p.host(key)
+	p.mu.Unlock()
+
+	select {
+	case hp.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mu.Lock()
+		n := len(hp.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		conn := hp.idle[n-1]
+		hp.idle = hp.idle[:n-1]
+		p.mu.Unlock()
+
+		if time.Since(conn.lastUsed) > p.maxIdleTime || !healthy(ctx, conn.client) {
+			conn.client.Close()
+			continue
+		}
+		return conn.client, nil
+	}
+
+	client, err := dial(ctx)
+	if err != nil {
+		<-hp.slots
+		return nil, err
+	}
+	return client, nil
+}
+
+// put returns a connection obtained from get. Broken connections, and
+// any connection returned after the pool is closed, are closed instead
+// of kept.
+func (p *connPool) put(key string, client *ssh.Client, broken bool) {
+	p.mu.Lock()
+	hp := p.host(key)
+	if broken || p.closed {
+		p.mu.Unlock()
+		client.Close()
+	} else {
+		hp.idle = append(hp.idle, &pooledConn{client: client, lastUsed: time.Now()})
+		p.mu.Unlock()
+	}
+	<-hp.slots
+}
+
+// closeIdle closes connections that have been idle longer than the
+// maximum idle time.
+func (p *connPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, hp := range p.hosts {
+		kept := hp.idle[:0]
+		for _, conn := range hp.idle {
+			if time.Since(conn.lastUsed) > p.maxIdleTime {
+				conn.client.Close()
+				continue
+			}
+			kept = append(kept, conn)
+		}
+		hp.idle = kept
+	}
+}
+
+// close closes every idle connection. Connections in use are closed
+// when they are returned.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, hp := range p.hosts {
+		for _, conn := range hp.idle {
+			conn.client.Close()
+		}
+		hp.idle = nil
+	}
+}
+
+// healthy sends a keepalive request to check that the server is
+// still answering on the connection. A server that does not answer
+// within connectionRequestTimeout, or before ctx is done, is treated
+// as unhealthy, and closing the connection ends the request.
+func healthy(ctx context.Context, client *ssh.Client) bool {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+
+	timer := time.NewTimer(connectionRequestTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err == nil
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
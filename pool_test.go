@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPool_ReusesConnections(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.run(context.Background(), "", "true", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := server.loginCount(); n != 1 {
+		t.Errorf("expected commands to share one connection, got %d logins", n)
+	}
+}
+
+func TestPool_StalledServer(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	conn, err := client.dial(context.Background(), server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if !healthy(context.Background(), conn) {
+		t.Fatal("expected the connection to be healthy")
+	}
+
+	server.stall()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if healthy(ctx, conn) {
+		t.Error("expected a server that does not answer to be unhealthy")
+	}
+	if _, err := newSession(ctx, conn); err == nil {
+		t.Error("expected opening a session to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the checks to stop at the deadline, took %s", elapsed)
+	}
+}
+
+func TestPool_RunStopsAtDeadline(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := newTestClient(t, server)
+
+	if _, err := client.run(context.Background(), "", "true", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	server.stall()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.run(ctx, "", "true", "")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the command to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected run to return once the context is done")
+	}
+}
@@ -0,0 +1,237 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// pendingRevocationStoragePath holds revocations
+	// that failed and are waiting to be retried.
+	pendingRevocationStoragePath = "revocation/pending/"
+
+	minRevocationBackoff = time.Minute
+	maxRevocationBackoff = time.Hour
+
+	// maxRevocationAttempts is how often a revocation is attempted,
+	// about two days of hourly retries, before it is abandoned
+	maxRevocationAttempts = 48
+)
+
+// pendingRevocation holds everything needed to remove the
+// credentials of a lease that ended while its host could
+// not be reached.
+type pendingRevocation struct {
+	ID                   string    `json:"id"`
+	LeaseID              string    `json:"lease_id"`
+	Role                 string    `json:"role"`
+	Connection           string    `json:"connection"`
+	HostKeyPolicy        string    `json:"host_key_policy,omitempty"`
+	Host                 string    `json:"host"`
+	Username             string    `json:"username"`
+	PublicKey            string    `json:"public_key,omitempty"`
+	ExistingAccount      bool      `json:"existing_account,omitempty"`
+	RevocationStatements []string  `json:"revocation_statements,omitempty"`
+	Attempts             int       `json:"attempts"`
+	LastError            string    `json:"last_error"`
+	LeaseEnded           time.Time `json:"lease_ended"`
+	NextAttempt          time.Time `json:"next_attempt"`
+}
+
+// toResponseData returns response data for a pending revocation
+func (r *pendingRevocation) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"lease_id":     r.LeaseID,
+		"role":         r.Role,
+		"host":         r.Host,
+		"username":     r.Username,
+		"attempts":     r.Attempts,
+		"last_error":   r.LastError,
+		"lease_ended":  r.LeaseEnded,
+		"next_attempt": r.NextAttempt,
+	}
+}
+
+// failed records a failed attempt and schedules the next one,
+// doubling the delay after each failure.
+func (r *pendingRevocation) failed(err error) {
+	r.Attempts++
+	r.LastError = err.Error()
+	r.NextAttempt = time.Now().Add(revocationBackoff(r.Attempts))
+}
+
+// revocationBackoff returns the delay before the next attempt
+// to revoke credentials that failed to revoke attempts times.
+func revocationBackoff(attempts int) time.Duration {
+	backoff := minRevocationBackoff
+	for i := 1; i < attempts && backoff < maxRevocationBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRevocationBackoff)
+}
+
+// queueRevocation stores a revocation that failed so it is retried
+// until the credentials are removed from the host.
+func queueRevocation(ctx context.Context, s logical.Storage, revocation *pendingRevocation, revokeErr error) error {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	revocation.ID = id
+	revocation.LeaseEnded = time.Now()
+	revocation.failed(revokeErr)
+
+	return setPendingRevocation(ctx, s, revocation)
+}
+
+// retryPendingRevocations retries the queued revocations that are
+// due. Revocations that fail again are scheduled for a later attempt,
+// until they have failed maxRevocationAttempts times and are abandoned.
+func (b *shellBackend) retryPendingRevocations(ctx context.Context, s logical.Storage) error {
+	ids, err := s.List(ctx, pendingRevocationStoragePath)
+	if err != nil {
+		return err
+	}
+
+	b.revocationLock.Lock()
+	defer b.revocationLock.Unlock()
+
+	var errs error
+	for _, id := range ids {
+		revocation, err := getPendingRevocation(ctx, s, id)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		if revocation == nil || time.Now().Before(revocation.NextAttempt) {
+			continue
+		}
+
+		b.Logger().Debug("retrying revocation", "lease_id", revocation.LeaseID, "host", revocation.Host, "username", revocation.Username, "attempts", revocation.Attempts)
+
+		start := time.Now()
+		err = b.retryRevocation(ctx, s, revocation)
+		emitOperationMetrics(historyOperationRevoke, revocation.Role, revocation.Host, start, err)
+		if err == nil {
+			b.recordEvent(ctx, s, hostRolePath, revocation.Role, historyEvent{
+				Operation: historyOperationRevoke,
+				Username:  revocation.Username,
+				Host:      revocation.Host,
+				LeaseID:   revocation.LeaseID,
+			}, nil)
+			continue
+		}
+
+		b.Logger().Warn("error retrying revocation", "lease_id", revocation.LeaseID, "host", revocation.Host, "username", revocation.Username, "error", err)
+
+		revocation.failed(err)
+		if revocation.Attempts >= maxRevocationAttempts {
+			reason := fmt.Errorf("abandoned after %d attempts: %w", revocation.Attempts, err)
+			if err := b.abandonRevocation(ctx, s, revocation, reason); err != nil {
+				errs = errors.Join(errs, err)
+			}
+			continue
+		}
+		if err := setPendingRevocation(ctx, s, revocation); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// abandonRevocation removes a revocation from the queue without removing
+// its credentials, and records why in the role's history. An account
+// created for the lease is no longer tracked, so tidy can remove it once
+// its host is reachable. Callers must hold the revocation lock.
+func (b *shellBackend) abandonRevocation(ctx context.Context, s logical.Storage, revocation *pendingRevocation, reason error) error {
+	b.Logger().Error("abandoning revocation, the credentials remain on the host", "lease_id", revocation.LeaseID, "host", revocation.Host, "username", revocation.Username, "reason", reason)
+
+	if !revocation.ExistingAccount {
+		if err := s.Delete(ctx, issuedAccountKey(revocation.Connection, revocation.Host, revocation.Username)); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Delete(ctx, pendingRevocationStoragePath+revocation.ID); err != nil {
+		return err
+	}
+
+	b.recordEvent(ctx, s, hostRolePath, revocation.Role, historyEvent{
+		Operation: historyOperationRevoke,
+		Username:  revocation.Username,
+		Host:      revocation.Host,
+		LeaseID:   revocation.LeaseID,
+		Outcome:   historyOutcomeAbandoned,
+		Error:     reason.Error(),
+	}, nil)
+	return nil
+}
+
+// retryRevocation removes the credentials of a queued revocation from
+// the host, then removes the revocation from the queue.
+func (b *shellBackend) retryRevocation(ctx context.Context, s logical.Storage, revocation *pendingRevocation) error {
+	client, err := b.getClient(ctx, s, revocation.Connection)
+	if err != nil {
+		return err
+	}
+	client = client.withHostKeyPolicy(revocation.HostKeyPolicy)
+
+	release, err := client.acquire(ctx, revocation.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := revokeCredentials(ctx, client, revocation.Host, revocation.Username, revocation.PublicKey, !revocation.ExistingAccount, revocation.RevocationStatements); err != nil {
+		return err
+	}
+
+	if !revocation.ExistingAccount {
+		if err := s.Delete(ctx, issuedAccountKey(revocation.Connection, revocation.Host, revocation.Username)); err != nil {
+			return err
+		}
+	}
+
+	return s.Delete(ctx, pendingRevocationStoragePath+revocation.ID)
+}
+
+// setPendingRevocation adds the pending revocation to the Vault storage API
+func setPendingRevocation(ctx context.Context, s logical.Storage, revocation *pendingRevocation) error {
+	entry, err := logical.StorageEntryJSON(pendingRevocationStoragePath+revocation.ID, revocation)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for pending revocation")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getPendingRevocation gets the pending revocation from the Vault storage API
+func getPendingRevocation(ctx context.Context, s logical.Storage, id string) (*pendingRevocation, error) {
+	entry, err := s.Get(ctx, pendingRevocationStoragePath+id)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var revocation pendingRevocation
+
+	if err := entry.DecodeJSON(&revocation); err != nil {
+		return nil, err
+	}
+	return &revocation, nil
+}
@@ -0,0 +1,255 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRevocation_QueuedAndRetried(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+	resp.Secret.LeaseID = "creds/web/lease"
+	username := resp.Data["username"].(string)
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		if strings.Contains(command, "userdel") {
+			return "", 1
+		}
+		return "", 0
+	})
+
+	// The lease ends even though the account is still on the host
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatalf("expected the failed revocation to be queued, got %v", err)
+	}
+
+	list := testRequest(t, b, s, logical.ListOperation, pendingRevocationsPath, nil)
+	ids := list.Data["keys"].([]string)
+	if len(ids) != 1 {
+		t.Fatalf("expected 1 pending revocation, got %#v", list.Data)
+	}
+	info := list.Data["key_info"].(map[string]interface{})[ids[0]].(map[string]interface{})
+	if info["lease_id"] != "creds/web/lease" || info["host"] != server.addr || info["username"] != username || info["attempts"] != 1 {
+		t.Errorf("expected the lease's account and host, got %#v", info)
+	}
+
+	ctx := testContext(t)
+	accountKey := issuedAccountKey(defaultConnectionName, server.addr, username)
+	if entry, err := s.Get(ctx, accountKey); err != nil || entry == nil {
+		t.Fatalf("expected the account to stay indexed until it is removed, got %v, %v", entry, err)
+	}
+
+	// Revocations are not retried before their next attempt
+	server.handle(nil)
+	if err := b.retryPendingRevocations(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.commandsContaining("userdel")); n != 1 {
+		t.Fatalf("expected no retry before the backoff elapsed, got %d userdel commands", n)
+	}
+
+	revocation, err := getPendingRevocation(ctx, s, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation.NextAttempt = time.Now().Add(-time.Second)
+	if err := setPendingRevocation(ctx, s, revocation); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.retryPendingRevocations(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := server.commandsContaining("userdel")
+	if len(deleted) != 2 || !strings.Contains(deleted[1].Command, "userdel -r "+username) {
+		t.Fatalf("expected the account to be deleted on retry, got %#v", deleted)
+	}
+	if revocation, err := getPendingRevocation(ctx, s, ids[0]); err != nil || revocation != nil {
+		t.Errorf("expected the revocation to be removed from the queue, got %#v, %v", revocation, err)
+	}
+	if entry, err := s.Get(ctx, accountKey); err != nil || entry != nil {
+		t.Errorf("expected the account to be removed from the index, got %v, %v", entry, err)
+	}
+
+	events := testHistory(t, b, s, hostRolePath+"web")
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %#v", events)
+	}
+	for i, outcome := range []string{historyOutcomePending, historyOutcomeSuccess} {
+		event := events[i+1]
+		if event["operation"] != historyOperationRevoke || event["outcome"] != outcome || event["lease_id"] != "creds/web/lease" {
+			t.Errorf("event %d: expected a revoke %s, got %#v", i+1, outcome, event)
+		}
+	}
+	if events[1]["error"] == nil {
+		t.Errorf("expected the pending revocation to record its error, got %#v", events[1])
+	}
+}
+
+func TestRevocation_RetryFailsAgain(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		return "", 1
+	})
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatalf("expected the failed revocation to be queued, got %v", err)
+	}
+
+	ctx := testContext(t)
+	ids, err := s.List(ctx, pendingRevocationStoragePath)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("expected 1 pending revocation, got %v, %v", ids, err)
+	}
+
+	revocation, err := getPendingRevocation(ctx, s, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation.NextAttempt = time.Now().Add(-time.Second)
+	if err := setPendingRevocation(ctx, s, revocation); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.retryPendingRevocations(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	revocation, err = getPendingRevocation(ctx, s, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revocation == nil || revocation.Attempts != 2 {
+		t.Fatalf("expected the revocation to stay queued after a second failure, got %#v", revocation)
+	}
+	if wait := time.Until(revocation.NextAttempt); wait <= minRevocationBackoff {
+		t.Errorf("expected the next attempt to back off, got %s", wait)
+	}
+}
+
+func TestRevocationBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  minRevocationBackoff,
+		2:  2 * minRevocationBackoff,
+		3:  4 * minRevocationBackoff,
+		20: maxRevocationBackoff,
+	} {
+		if got := revocationBackoff(attempts); got != want {
+			t.Errorf("revocationBackoff(%d): expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+// testQueuedRevocation issues credentials for a role on the server and
+// revokes them while userdel fails, returning the queued revocation.
+func testQueuedRevocation(t *testing.T, b *shellBackend, s logical.Storage, server *testSSHServer) *pendingRevocation {
+	t.Helper()
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+
+	server.handle(func(command, stdin string) (string, uint32) {
+		if strings.Contains(command, "userdel") {
+			return "", 1
+		}
+		return "", 0
+	})
+	if _, err := testRevoke(t, b, s, resp); err != nil {
+		t.Fatalf("expected the failed revocation to be queued, got %v", err)
+	}
+
+	ids, err := s.List(testContext(t), pendingRevocationStoragePath)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("expected 1 pending revocation, got %v, %v", ids, err)
+	}
+	revocation, err := getPendingRevocation(testContext(t), s, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revocation
+}
+
+// assertAbandoned checks that the revocation left the queue and
+// the account index, and was recorded as abandoned.
+func assertAbandoned(t *testing.T, b *shellBackend, s logical.Storage, revocation *pendingRevocation) {
+	t.Helper()
+
+	ctx := testContext(t)
+	if pending, err := getPendingRevocation(ctx, s, revocation.ID); err != nil || pending != nil {
+		t.Errorf("expected the revocation to be removed from the queue, got %#v, %v", pending, err)
+	}
+	if entry, err := s.Get(ctx, issuedAccountKey(revocation.Connection, revocation.Host, revocation.Username)); err != nil || entry != nil {
+		t.Errorf("expected the account to no longer be tracked, got %v, %v", entry, err)
+	}
+
+	events := testHistory(t, b, s, hostRolePath+"web")
+	last := events[len(events)-1]
+	if last["operation"] != historyOperationRevoke || last["outcome"] != historyOutcomeAbandoned || last["username"] != revocation.Username {
+		t.Errorf("expected the revocation to be recorded as abandoned, got %#v", last)
+	}
+}
+
+func TestRevocation_AbandonedAfterMaxAttempts(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	revocation := testQueuedRevocation(t, b, s, server)
+	revocation.Attempts = maxRevocationAttempts - 1
+	revocation.NextAttempt = time.Now().Add(-time.Second)
+	if err := setPendingRevocation(testContext(t), s, revocation); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.retryPendingRevocations(testContext(t), s); err != nil {
+		t.Fatal(err)
+	}
+
+	assertAbandoned(t, b, s, revocation)
+}
+
+func TestRevocation_Delete(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	revocation := testQueuedRevocation(t, b, s, server)
+
+	resp := testRequest(t, b, s, logical.ReadOperation, pendingRevocationsPath+revocation.ID, nil)
+	if resp == nil || resp.Data["username"] != revocation.Username {
+		t.Fatalf("expected the pending revocation, got %#v", resp)
+	}
+
+	testRequest(t, b, s, logical.DeleteOperation, pendingRevocationsPath+revocation.ID, nil)
+	assertAbandoned(t, b, s, revocation)
+
+	// Deleted revocations are not retried
+	if err := b.retryPendingRevocations(testContext(t), s); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.commandsContaining("userdel")); n != 1 {
+		t.Errorf("expected no retry after the revocation was deleted, got %d userdel commands", n)
+	}
+
+	// Deleting it again does nothing
+	testRequest(t, b, s, logical.DeleteOperation, pendingRevocationsPath+revocation.ID, nil)
+}
@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// periodicFunc is called by Vault about once a minute. It
+// rotates the passwords of static roles that are due, retries
+// pending revocations and tidies orphaned accounts when
+// tidying is configured.
+func (b *shellBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	b.closeIdleConnections()
+
+	// Only the node that can write to storage rotates passwords
+	replState := b.System().ReplicationState()
+	if (!b.System().LocalMount() && replState.HasState(consts.ReplicationPerformanceSecondary)) ||
+		replState.HasState(consts.ReplicationDRSecondary) ||
+		replState.HasState(consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	return errors.Join(
+		b.rotateExpiredStaticRoles(ctx, req.Storage),
+		b.retryPendingRevocations(ctx, req.Storage),
+		b.periodicTidy(ctx, req.Storage),
+	)
+}
+
+// rotateExpiredStaticRoles rotates the password of every static
+// role whose rotation period has elapsed.
+func (b *shellBackend) rotateExpiredStaticRoles(ctx context.Context, s logical.Storage) error {
+	names, err := s.List(ctx, staticRolePath)
+	if err != nil {
+		return err
+	}
+
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	var errs error
+	for _, name := range names {
+		role, err := getStaticRole(ctx, s, name)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+
+		if role == nil || time.Now().Before(role.nextRotation()) {
+			continue
+		}
+
+		b.Logger().Debug("rotating static role password", "role", name, "host", role.Host)
+
+		if err := b.rotateStaticRole(ctx, s, role); err != nil {
+			b.Logger().Error("error rotating static role password", "role", name, "error", err)
+			errs = errors.Join(errs, fmt.Errorf("error rotating static role %q: %w", name, err))
+		}
+	}
+
+	return errs
+}
+
+// rotateStaticRole sets a newly generated password for the
+// static role's account, stores it and records the rotation
+// in the role's history. Callers must hold the rotation lock.
+func (b *shellBackend) rotateStaticRole(ctx context.Context, s logical.Storage, role *staticRoleEntry) (err error) {
+	defer func(start time.Time) {
+		emitOperationMetrics(historyOperationRotate, role.Name, role.Host, start, err)
+		b.recordEvent(ctx, s, staticRolePath, role.Name, historyEvent{
+			Operation: historyOperationRotate,
+			Username:  role.Username,
+			Host:      role.Host,
+		}, err)
+	}(time.Now())
+
+	client, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return err
+	}
+	client = client.withHostKeyPolicy(role.HostKeyPolicy)
+
+	release, err := client.acquire(ctx, role.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	config, err := getConfig(ctx, s, role.Connection)
+	if err != nil {
+		return fmt.Errorf("unable to read configuration: %w", err)
+	}
+
+	password, err := b.generateUniquePassword(ctx, config.PasswordPolicy, role.PasswordHistory)
+	if err != nil {
+		return err
+	}
+
+	history, err := role.PasswordHistory.add(password)
+	if err != nil {
+		return err
+	}
+
+	// Record the rotation so the host is reset to the stored
+	// password if the new one is set but never stored.
+	walID, err := framework.PutWAL(ctx, s, walTypeStaticRotation, &walAccount{
+		Role:       role.Name,
+		Connection: role.Connection,
+		Host:       role.Host,
+		Username:   role.Username,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	if err := setStaticPassword(ctx, client, role, password, time.Now().Add(role.RotationPeriod)); err != nil {
+		return err
+	}
+
+	role.Password = password
+	role.PasswordHistory = history
+	role.LastVaultRotation = time.Now()
+
+	if err := setStaticRole(ctx, s, role.Name, role); err != nil {
+		return err
+	}
+
+	return framework.DeleteWAL(ctx, s, walID)
+}
+
+// setStaticPassword sets the static role's password on the host, with
+// the role's rotation statements if it has any. expiration is when the
+// password is next due for rotation.
+func setStaticPassword(ctx context.Context, c *shellClient, role *staticRoleEntry, password string, expiration time.Time) error {
+	if len(role.RotationStatements) > 0 {
+		return c.runStatements(ctx, role.Host, role.RotationStatements, statementValues(role.Username, password, expiration))
+	}
+	return c.setPassword(ctx, role.Host, role.Username, password)
+}
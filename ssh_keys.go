@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/base62"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	credentialTypePassword = "password"
+	credentialTypeSSHKey   = "ssh_key"
+
+	// credentialTypeCertificate roles sign caller's keys
+	// through sign/ instead of issuing credentials
+	credentialTypeCertificate = "certificate"
+
+	keyTypeED25519 = "ed25519"
+	keyTypeRSA     = "rsa"
+
+	defaultRSAKeyBits = 4096
+)
+
+// validKeyBits lists the RSA key sizes a role may request.
+var validKeyBits = map[int]bool{
+	2048: true,
+	3072: true,
+	4096: true,
+}
+
+// generateSSHKeyPair creates a key pair for the account. It returns the
+// private key in OpenSSH PEM format and the public key as a single
+// authorized_keys line whose comment identifies the lease.
+func generateSSHKeyPair(keyType string, keyBits int, username string) (string, string, error) {
+	suffix, err := base62.Random(8)
+	if err != nil {
+		return "", "", err
+	}
+	comment := "vault-" + username + "-" + suffix
+
+	var privateKey crypto.PrivateKey
+	var publicKey crypto.PublicKey
+
+	switch keyType {
+	case keyTypeRSA:
+		if keyBits == 0 {
+			keyBits = defaultRSAKeyBits
+		}
+		key, err := rsa.GenerateKey(rand.Reader, keyBits)
+		if err != nil {
+			return "", "", fmt.Errorf("error generating RSA key: %w", err)
+		}
+		privateKey, publicKey = key, key.Public()
+	case keyTypeED25519, "":
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", fmt.Errorf("error generating ed25519 key: %w", err)
+		}
+		privateKey, publicKey = private, public
+	default:
+		return "", "", fmt.Errorf("unsupported key type %q", keyType)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		return "", "", fmt.Errorf("error encoding private key: %w", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", "", fmt.Errorf("error encoding public key: %w", err)
+	}
+
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey))) + " " + comment
+
+	return string(pem.EncodeToMemory(block)), authorizedKey, nil
+}
@@ -0,0 +1,201 @@
+package secrets
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testCommand is a command received by a testSSHServer
+// and the standard input it was given.
+type testCommand struct {
+	Command string
+	Stdin   string
+}
+
+// testSSHServer is an in-process SSH server that accepts any password,
+// records the commands it is asked to run and answers them with handler.
+type testSSHServer struct {
+	addr     string
+	hostKey  ssh.Signer
+	listener net.Listener
+
+	mu       sync.Mutex
+	received []testCommand
+	logins   int
+	stalled  bool
+	handler  func(command, stdin string) (stdout string, exitStatus uint32)
+}
+
+// newTestSSHServer starts a server on a random local port. It
+// is stopped when the test ends.
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testSSHServer{
+		addr:     listener.Addr().String(),
+		hostKey:  hostKey,
+		listener: listener,
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			server.mu.Lock()
+			server.logins++
+			server.mu.Unlock()
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+
+	t.Cleanup(server.stop)
+	return server
+}
+
+// stop closes the listener so new connections to the server fail.
+func (s *testSSHServer) stop() {
+	s.listener.Close()
+}
+
+// handle sets the function that answers commands. Without one,
+// every command succeeds with no output.
+func (s *testSSHServer) handle(handler func(command, stdin string) (string, uint32)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// stall makes the server stop answering requests and session
+// requests on open connections, as a hung server would.
+func (s *testSSHServer) stall() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stalled = true
+}
+
+func (s *testSSHServer) isStalled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stalled
+}
+
+// commands returns the commands received so far.
+func (s *testSSHServer) commands() []testCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]testCommand(nil), s.received...)
+}
+
+// commandsContaining returns the received commands that contain substr.
+func (s *testSSHServer) commandsContaining(substr string) []testCommand {
+	var matched []testCommand
+	for _, command := range s.commands() {
+		if strings.Contains(command.Command, substr) {
+			matched = append(matched, command)
+		}
+	}
+	return matched
+}
+
+// loginCount returns how many times a client logged in.
+func (s *testSSHServer) loginCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go func() {
+		for req := range reqs {
+			if !s.isStalled() {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	for newChannel := range chans {
+		if s.isStalled() {
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(channel, requests)
+	}
+}
+
+// session runs the exec request of a single session, replying
+// with the handler's output and exit status.
+func (s *testSSHServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var exec struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &exec); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		stdin, _ := io.ReadAll(channel)
+
+		s.mu.Lock()
+		s.received = append(s.received, testCommand{Command: exec.Command, Stdin: string(stdin)})
+		handler := s.handler
+		s.mu.Unlock()
+
+		var stdout string
+		var exitStatus uint32
+		if handler != nil {
+			stdout, exitStatus = handler(exec.Command, string(stdin))
+		}
+
+		io.WriteString(channel, stdout)
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{exitStatus}))
+		return
+	}
+}
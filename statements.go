@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// passwordPlaceholder is substituted with a reference to
+	// passwordVariable rather than with the password itself.
+	passwordPlaceholder = "{{password}}"
+
+	// passwordVariable holds the password while a statement runs. It
+	// is read from the statement's standard input, so the password is
+	// never part of the command line sent to the host.
+	passwordVariable = "vault_password"
+)
+
+// statementValues returns the values substituted for the {{username}},
+// {{password}}, {{expiration}} and {{expiration_date}} placeholders in
+// role statements.
+func statementValues(username, password string, expiration time.Time) map[string]string {
+	values := map[string]string{
+		"username": username,
+		"password": password,
+	}
+	if !expiration.IsZero() {
+		values["expiration"] = expiration.UTC().Format(time.RFC3339)
+		values["expiration_date"] = accountExpiryDate(expiration)
+	}
+	return values
+}
+
+// renderStatement substitutes the placeholders in a statement. Each value
+// is quoted for the shell, so placeholders must not be quoted again.
+// {{password}} becomes a quoted reference to a variable that the
+// rendered statement first reads from its standard input.
+func renderStatement(statement string, values map[string]string) string {
+	usesPassword := strings.Contains(statement, passwordPlaceholder)
+	statement = strings.ReplaceAll(statement, passwordPlaceholder, `"$`+passwordVariable+`"`)
+
+	for name, value := range values {
+		if name == "password" {
+			continue
+		}
+		statement = strings.ReplaceAll(statement, "{{"+name+"}}", shellQuote(value))
+	}
+
+	if usesPassword {
+		statement = "IFS= read -r " + passwordVariable + "\n" + statement
+	}
+	return statement
+}
+
+// statementStdin returns the standard input for a statement, which
+// carries the password if the statement uses it.
+func statementStdin(statement string, values map[string]string) string {
+	if !strings.Contains(statement, passwordPlaceholder) {
+		return ""
+	}
+	return values["password"] + "\n"
+}
+
+// shellQuote quotes a value as a single shell word.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// runStatements renders and runs each statement on the host in order,
+// stopping at the first one that fails.
+func (c *shellClient) runStatements(ctx context.Context, host string, statements []string, values map[string]string) error {
+	for i, statement := range statements {
+		if _, err := c.run(ctx, host, renderStatement(statement, values), statementStdin(statement, values)); err != nil {
+			return fmt.Errorf("statement %d failed: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRenderStatement(t *testing.T) {
+	expiration := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	values := statementValues("alice", "s3cr'et", expiration)
+
+	statement := "useradd -e {{expiration_date}} {{username}} && echo {{username}}:{{password}} | chpasswd"
+	rendered := renderStatement(statement, values)
+
+	if strings.Contains(rendered, "s3cr") {
+		t.Fatalf("expected the password to be left out of the command, got %q", rendered)
+	}
+	want := "IFS= read -r vault_password\nuseradd -e '2026-03-15' 'alice' && echo 'alice':\"$vault_password\" | chpasswd"
+	if rendered != want {
+		t.Errorf("expected %q, got %q", want, rendered)
+	}
+	if stdin := statementStdin(statement, values); stdin != "s3cr'et\n" {
+		t.Errorf("expected the password on stdin, got %q", stdin)
+	}
+
+	rendered = renderStatement("echo {{expiration}}", values)
+	if rendered != "echo '2026-03-14T15:09:26Z'" {
+		t.Errorf("expected the RFC 3339 expiration, got %q", rendered)
+	}
+	if stdin := statementStdin("echo {{expiration}}", values); stdin != "" {
+		t.Errorf("expected no stdin for a statement without a password, got %q", stdin)
+	}
+}
+
+func TestRenderStatement_Shell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	password := `p'a"s$s` + "`w`\\d"
+	values := statementValues("alice", password, time.Time{})
+	statement := "echo {{username}}:{{password}}"
+
+	cmd := exec.Command(sh, "-c", renderStatement(statement, values))
+	cmd.Stdin = strings.NewReader(statementStdin(statement, values))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "alice:"+password+"\n" {
+		t.Errorf("expected the password to reach the command unchanged, got %q", out)
+	}
+}
+
+func TestStatements_Creation(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"app", map[string]interface{}{
+		"host": server.addr,
+		"ttl":  "1h",
+		"creation_statements": []string{
+			"useradd -m -e {{expiration_date}} {{username}}",
+			"echo {{username}}:{{password}} | chpasswd",
+		},
+	})
+
+	resp := testRequest(t, b, s, logical.ReadOperation, credsPath+"app", nil)
+	username := resp.Data["username"].(string)
+	password := resp.Data["password"].(string)
+
+	// The name is checked to be free before the statements run
+	commands := server.commandsContaining(username)
+	if len(commands) != 3 || !strings.HasPrefix(commands[0].Command, "if id -u "+username) {
+		t.Fatalf("expected both statements to run, got %#v", server.commands())
+	}
+	commands = commands[1:]
+
+	expiry := accountExpiryDate(time.Now().Add(time.Hour))
+	if want := "useradd -m -e '" + expiry + "' '" + username + "'"; commands[0].Command != want {
+		t.Errorf("expected %q, got %q", want, commands[0].Command)
+	}
+	for _, command := range commands {
+		if strings.Contains(command.Command, password) {
+			t.Errorf("expected the password to be left out of %q", command.Command)
+		}
+	}
+	if commands[1].Stdin != password+"\n" {
+		t.Errorf("expected the password on stdin, got %q", commands[1].Stdin)
+	}
+}
@@ -0,0 +1,436 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// issuedAccountStoragePath indexes the accounts created for
+	// outstanding leases by connection, host and username.
+	issuedAccountStoragePath = "account/"
+
+	tidyConfigStoragePath = "tidy/config"
+
+	// defaultTidyUsernamePrefix matches the default username template.
+	defaultTidyUsernamePrefix = "v-"
+)
+
+// tidyConfig controls how orphaned accounts are cleaned up.
+// An interval of zero disables automatic tidying, and accounts
+// are only reported until DryRun is turned off.
+type tidyConfig struct {
+	Interval       time.Duration `json:"interval"`
+	UsernamePrefix string        `json:"username_prefix"`
+	DryRun         bool          `json:"dry_run"`
+}
+
+// issuedAccount records an account created on a host for a lease.
+// The entry is removed when the lease is revoked.
+type issuedAccount struct {
+	Role          string `json:"role"`
+	Connection    string `json:"connection"`
+	HostKeyPolicy string `json:"host_key_policy,omitempty"`
+	Host          string `json:"host"`
+	Username      string `json:"username"`
+}
+
+// tidyTarget is a host that tidy checks for orphaned accounts.
+type tidyTarget struct {
+	connection    string
+	hostKeyPolicy string
+	host          string
+	tracked       map[string]bool
+}
+
+// tidyResult reports the orphaned accounts a tidy run found.
+type tidyResult struct {
+	hosts    int
+	orphaned []string
+	removed  []string
+	errs     []string
+}
+
+func (r *tidyResult) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"hosts":             r.hosts,
+		"orphaned_accounts": r.orphaned,
+		"removed_accounts":  r.removed,
+		"errors":            r.errs,
+	}
+}
+
+// issuedAccountKey returns the storage key of an issued account.
+func issuedAccountKey(connection, host, username string) string {
+	if connection == "" {
+		connection = defaultConnectionName
+	}
+	return issuedAccountStoragePath + connection + "/" + knownHostName(host) + "/" + username
+}
+
+// setIssuedAccount adds the issued account to the Vault storage API
+func setIssuedAccount(ctx context.Context, s logical.Storage, account *issuedAccount) error {
+	entry, err := logical.StorageEntryJSON(issuedAccountKey(account.Connection, account.Host, account.Username), account)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return fmt.Errorf("failed to create storage entry for issued account")
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// tidyAccounts finds accounts with the tidy username prefix on the hosts
+// known to the mount that no outstanding lease was issued for, and
+// deletes them unless dryRun is set.
+func (b *shellBackend) tidyAccounts(ctx context.Context, s logical.Storage, config *tidyConfig, dryRun bool) (*tidyResult, error) {
+	targets, err := b.tidyTargets(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := b.managedUsernames(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accounts being created right now are not indexed yet
+	pending, err := pendingUsernames(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &tidyResult{}
+	for _, target := range targets {
+		result.hosts++
+
+		client, err := b.getClient(ctx, s, target.connection)
+		if err != nil {
+			result.errs = append(result.errs, fmt.Sprintf("%s: %s", target.host, err))
+			continue
+		}
+		client = client.withHostKeyPolicy(target.hostKeyPolicy)
+
+		usernames, err := listHostUsers(ctx, client, target.host)
+		if err != nil {
+			result.errs = append(result.errs, fmt.Sprintf("%s: %s", target.host, err))
+			continue
+		}
+
+		for _, username := range usernames {
+			if !strings.HasPrefix(username, config.UsernamePrefix) ||
+				target.tracked[username] || protected[username] || pending[username] {
+				continue
+			}
+
+			// A lease may have been issued for the account since
+			// storage was read at the start of the run
+			inUse, err := accountInUse(ctx, s, target, username)
+			if err != nil {
+				result.errs = append(result.errs, fmt.Sprintf("%s@%s: %s", username, target.host, err))
+				continue
+			}
+			if inUse {
+				continue
+			}
+
+			account := username + "@" + target.host
+			result.orphaned = append(result.orphaned, account)
+			if dryRun {
+				continue
+			}
+
+			b.Logger().Info("removing orphaned account", "host", target.host, "username", username)
+
+			if err := deleteOrphanedUser(ctx, client, target.host, username); err != nil {
+				result.errs = append(result.errs, fmt.Sprintf("%s: %s", account, err))
+				continue
+			}
+			result.removed = append(result.removed, account)
+		}
+	}
+
+	return result, nil
+}
+
+// listHostUsers lists the accounts on the host within
+// the connection's limits.
+func listHostUsers(ctx context.Context, c *shellClient, host string) ([]string, error) {
+	release, err := c.acquire(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return c.listUsers(ctx, host)
+}
+
+// deleteOrphanedUser deletes an account from the host within
+// the connection's limits.
+func deleteOrphanedUser(ctx context.Context, c *shellClient, host, username string) error {
+	release, err := c.acquire(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return c.deleteUser(ctx, host, username)
+}
+
+// tidyTargets returns the hosts of the mount's roles and of
+// its issued accounts, with the accounts issued on each.
+func (b *shellBackend) tidyTargets(ctx context.Context, s logical.Storage) ([]*tidyTarget, error) {
+	targets := make(map[string]*tidyTarget)
+	addTarget := func(connection, hostKeyPolicy, host string) *tidyTarget {
+		if connection == "" {
+			connection = defaultConnectionName
+		}
+		key := connection + "/" + knownHostName(host)
+		target, ok := targets[key]
+		if !ok {
+			target = &tidyTarget{
+				connection:    connection,
+				hostKeyPolicy: hostKeyPolicy,
+				host:          host,
+				tracked:       make(map[string]bool),
+			}
+			targets[key] = target
+		}
+		return target
+	}
+
+	roleNames, err := s.List(ctx, hostRolePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range roleNames {
+		role, err := b.getRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		if role.Host != "" && role.credentialType() != credentialTypeCertificate {
+			addTarget(role.Connection, role.HostKeyPolicy, role.Host)
+		}
+	}
+
+	connections, err := s.List(ctx, issuedAccountStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, connection := range connections {
+		hosts, err := s.List(ctx, issuedAccountStoragePath+connection)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			prefix := issuedAccountStoragePath + connection + host
+			usernames, err := s.List(ctx, prefix)
+			if err != nil {
+				return nil, err
+			}
+			for _, username := range usernames {
+				entry, err := s.Get(ctx, prefix+username)
+				if err != nil {
+					return nil, err
+				}
+				if entry == nil {
+					continue
+				}
+				var account issuedAccount
+				if err := entry.DecodeJSON(&account); err != nil {
+					return nil, err
+				}
+				addTarget(account.Connection, account.HostKeyPolicy, account.Host).tracked[account.Username] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(targets))
+	for key := range targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]*tidyTarget, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, targets[key])
+	}
+	return sorted, nil
+}
+
+// managedUsernames returns the existing accounts the mount manages
+// through roles, static roles and library sets, which tidy never removes.
+func (b *shellBackend) managedUsernames(ctx context.Context, s logical.Storage) (map[string]bool, error) {
+	usernames := make(map[string]bool)
+
+	roleNames, err := s.List(ctx, hostRolePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range roleNames {
+		role, err := b.getRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		if role.Username != "" {
+			usernames[role.Username] = true
+		}
+	}
+
+	staticRoleNames, err := s.List(ctx, staticRolePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range staticRoleNames {
+		role, err := getStaticRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			usernames[role.Username] = true
+		}
+	}
+
+	setNames, err := s.List(ctx, libraryPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range setNames {
+		set, err := getLibrarySet(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if set == nil {
+			continue
+		}
+		for _, username := range set.ServiceAccountNames {
+			usernames[username] = true
+		}
+	}
+
+	return usernames, nil
+}
+
+// accountInUse reads storage again to check whether an account on the
+// target belongs to a lease that is being or was issued. WAL entries
+// are read before the index, since an account is indexed before its
+// WAL entry is removed.
+func accountInUse(ctx context.Context, s logical.Storage, target *tidyTarget, username string) (bool, error) {
+	pending, err := pendingUsernames(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	if pending[username] {
+		return true, nil
+	}
+
+	entry, err := s.Get(ctx, issuedAccountKey(target.connection, target.host, username))
+	if err != nil {
+		return false, err
+	}
+	return entry != nil, nil
+}
+
+// pendingUsernames returns the accounts recorded in WAL entries,
+// which are still being created or are waiting to be rolled back.
+func pendingUsernames(ctx context.Context, s logical.Storage) (map[string]bool, error) {
+	usernames := make(map[string]bool)
+
+	ids, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		entry, err := framework.GetWAL(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil || entry.Kind != walTypeAccount {
+			continue
+		}
+		var account walAccount
+		if err := mapstructure.Decode(entry.Data, &account); err != nil {
+			return nil, err
+		}
+		usernames[account.Username] = true
+	}
+
+	return usernames, nil
+}
+
+// periodicTidy tidies orphaned accounts once the configured
+// interval has passed since the last run.
+func (b *shellBackend) periodicTidy(ctx context.Context, s logical.Storage) error {
+	config, err := getTidyConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if config.Interval == 0 {
+		return nil
+	}
+
+	// Skip this run if an on-demand tidy is in progress
+	if !b.tidyLock.TryLock() {
+		return nil
+	}
+	defer b.tidyLock.Unlock()
+
+	if time.Since(b.lastTidy) < config.Interval {
+		return nil
+	}
+
+	result, err := b.tidyAccounts(ctx, s, config, config.DryRun)
+	if err != nil {
+		return err
+	}
+	b.lastTidy = time.Now()
+
+	if len(result.orphaned) > 0 {
+		b.Logger().Warn("found orphaned accounts", "accounts", result.orphaned, "removed", len(result.removed))
+	}
+
+	if len(result.errs) > 0 {
+		return fmt.Errorf("error tidying accounts: %s", strings.Join(result.errs, "; "))
+	}
+
+	return nil
+}
+
+// getTidyConfig gets the tidy configuration from the Vault storage API,
+// or the defaults if it has not been written
+func getTidyConfig(ctx context.Context, s logical.Storage) (*tidyConfig, error) {
+	config := &tidyConfig{
+		UsernamePrefix: defaultTidyUsernamePrefix,
+		DryRun:         true,
+	}
+
+	entry, err := s.Get(ctx, tidyConfigStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return config, nil
+	}
+
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
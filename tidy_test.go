@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// listAccounts answers getent passwd with the accounts and
+// calls onList, if set, while the accounts are being listed.
+func listAccounts(accounts []string, onList func()) func(command, stdin string) (string, uint32) {
+	return func(command, stdin string) (string, uint32) {
+		if strings.HasPrefix(command, "getent passwd") {
+			if onList != nil {
+				onList()
+			}
+			return strings.Join(accounts, "\n") + "\n", 0
+		}
+		return "", 0
+	}
+}
+
+func TestTidy_RemovesOrphanedAccounts(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	issued := testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil).Data["username"].(string)
+
+	server.handle(listAccounts([]string{"root", "admin", issued, "v-web-orphaned"}, nil))
+
+	// Tidy only reports orphaned accounts by default
+	resp := testRequest(t, b, s, logical.UpdateOperation, tidyPath, nil)
+	orphaned := resp.Data["orphaned_accounts"].([]string)
+	if len(orphaned) != 1 || orphaned[0] != "v-web-orphaned@"+server.addr {
+		t.Fatalf("expected the orphaned account to be reported, got %v", orphaned)
+	}
+	if n := len(server.commandsContaining("userdel")); n != 0 {
+		t.Fatalf("expected nothing to be removed in a dry run, got %d", n)
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, tidyPath, map[string]interface{}{
+		"dry_run": false,
+	})
+	if removed := resp.Data["removed_accounts"].([]string); len(removed) != 1 {
+		t.Fatalf("expected the orphaned account to be removed, got %v", removed)
+	}
+
+	deleted := server.commandsContaining("userdel")
+	if len(deleted) != 1 || !strings.Contains(deleted[0].Command, "userdel -r v-web-orphaned") {
+		t.Fatalf("expected only the orphaned account to be deleted, got %#v", deleted)
+	}
+}
+
+func TestTidy_KeepsAccountsIssuedDuringRun(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+	testRequest(t, b, s, logical.ReadOperation, credsPath+"web", nil)
+
+	// Leases for these accounts are issued after tidy read
+	// storage, but before it reaches them on the host
+	server.handle(listAccounts([]string{"v-web-creating", "v-web-indexed"}, func() {
+		if _, err := framework.PutWAL(context.Background(), s, walTypeAccount, &walAccount{
+			Role:     "web",
+			Host:     server.addr,
+			Username: "v-web-creating",
+		}); err != nil {
+			t.Error(err)
+		}
+		if err := setIssuedAccount(context.Background(), s, &issuedAccount{
+			Role:     "web",
+			Host:     server.addr,
+			Username: "v-web-indexed",
+		}); err != nil {
+			t.Error(err)
+		}
+	}))
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, tidyPath, map[string]interface{}{
+		"dry_run": false,
+	})
+	if orphaned := resp.Data["orphaned_accounts"].([]string); len(orphaned) != 0 {
+		t.Errorf("expected no orphaned accounts, got %v", orphaned)
+	}
+	if n := len(server.commandsContaining("userdel")); n != 0 {
+		t.Errorf("expected no accounts to be removed, got %d", n)
+	}
+}
+
+func TestTidy_ConfigDefaultsToDryRun(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	resp := testRequest(t, b, s, logical.ReadOperation, tidyConfigPath, nil)
+	if resp.Data["dry_run"] != true {
+		t.Errorf("expected automatic tidying to default to a dry run, got %v", resp.Data["dry_run"])
+	}
+}
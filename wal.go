@@ -0,0 +1,223 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	walTypeAccount         = "account"
+	walTypeStaticRotation  = "static-rotation"
+	walTypeRootRotation    = "root-rotation"
+	walTypeLibraryRotation = "library-rotation"
+
+	// walRollbackMinAge leaves enough time for an in-flight
+	// remote command to finish before its WAL entry is rolled back.
+	walRollbackMinAge = 5 * time.Minute
+)
+
+// walAccount records an account that is about to be created or have
+// its password rotated on a host. The entry is removed once the account
+// is returned in a lease or its new password has been stored. For
+// library rotations, Role holds the name of the account's set.
+type walAccount struct {
+	Role            string `json:"role" mapstructure:"role"`
+	Connection      string `json:"connection,omitempty" mapstructure:"connection"`
+	HostKeyPolicy   string `json:"host_key_policy,omitempty" mapstructure:"host_key_policy"`
+	Host            string `json:"host" mapstructure:"host"`
+	Username        string `json:"username" mapstructure:"username"`
+	PublicKey       string `json:"public_key,omitempty" mapstructure:"public_key"`
+	ExistingAccount bool   `json:"existing_account,omitempty" mapstructure:"existing_account"`
+
+	RevocationStatements []string `json:"revocation_statements,omitempty" mapstructure:"revocation_statements"`
+}
+
+// walRootRotation records a rotation of a connection's admin password
+// across its hosts. The entry is removed once the new password has been
+// stored, or every host has been set back to the previous one. Only a
+// hash of the previous password is kept, since it stays in the
+// configuration until the rotation completes.
+type walRootRotation struct {
+	Connection   string             `json:"connection" mapstructure:"connection"`
+	Username     string             `json:"username" mapstructure:"username"`
+	Hosts        []rootRotationHost `json:"hosts" mapstructure:"hosts"`
+	PasswordHash passwordHash       `json:"password_hash" mapstructure:"password_hash"`
+	NewPassword  string             `json:"new_password" mapstructure:"new_password"`
+}
+
+// walRollback is called by Vault for WAL entries older than
+// walRollbackMinAge. Returning nil removes the entry.
+func (b *shellBackend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	switch kind {
+	case walTypeAccount:
+		return b.rollbackAccount(ctx, req.Storage, data)
+	case walTypeStaticRotation:
+		return b.rollbackStaticRotation(ctx, req.Storage, data)
+	case walTypeRootRotation:
+		return b.rollbackRootRotation(ctx, req.Storage, data)
+	case walTypeLibraryRotation:
+		return b.rollbackLibraryRotation(ctx, req.Storage, data)
+	default:
+		return fmt.Errorf("unknown WAL entry type %q", kind)
+	}
+}
+
+// rollbackAccount deletes an account that was created on a host but
+// never handed out in a lease. For existing accounts, only the public
+// key installed for the lease is removed.
+func (b *shellBackend) rollbackAccount(ctx context.Context, s logical.Storage, data interface{}) error {
+	var entry walAccount
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	// An account indexed for a lease was issued by a later request
+	// under the same name, and belongs to that lease
+	if !entry.ExistingAccount {
+		issued, err := s.Get(ctx, issuedAccountKey(entry.Connection, entry.Host, entry.Username))
+		if err != nil {
+			return err
+		}
+		if issued != nil {
+			return nil
+		}
+	}
+
+	client, err := b.getClient(ctx, s, entry.Connection)
+	if err != nil {
+		return err
+	}
+	client = client.withHostKeyPolicy(entry.HostKeyPolicy)
+
+	release, err := client.acquire(ctx, entry.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	b.Logger().Debug("rolling back account", "host", entry.Host, "username", entry.Username)
+
+	return revokeCredentials(ctx, client, entry.Host, entry.Username, entry.PublicKey, !entry.ExistingAccount, entry.RevocationStatements)
+}
+
+// rollbackStaticRotation sets the account's password back to the one
+// stored for the static role, in case the host accepted a new password
+// that was never stored.
+func (b *shellBackend) rollbackStaticRotation(ctx context.Context, s logical.Storage, data interface{}) error {
+	var entry walAccount
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	role, err := getStaticRole(ctx, s, entry.Role)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to restore if the role was removed, points at another
+	// account, or never stored a password
+	if role == nil || role.Connection != entry.Connection || role.Host != entry.Host || role.Username != entry.Username || role.Password == "" {
+		return nil
+	}
+
+	client, err := b.getClient(ctx, s, role.Connection)
+	if err != nil {
+		return err
+	}
+	client = client.withHostKeyPolicy(role.HostKeyPolicy)
+
+	release, err := client.acquire(ctx, role.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	b.Logger().Debug("rolling back static role rotation", "role", role.Name, "host", role.Host)
+
+	return setStaticPassword(ctx, client, role, role.Password, role.nextRotation())
+}
+
+// rollbackLibraryRotation sets the account's password back to the one
+// stored for it in its library set, in case the host accepted a new
+// password that was never stored.
+func (b *shellBackend) rollbackLibraryRotation(ctx context.Context, s logical.Storage, data interface{}) error {
+	var entry walAccount
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	b.libraryLock.Lock()
+	defer b.libraryLock.Unlock()
+
+	set, err := getLibrarySet(ctx, s, entry.Role)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to restore if the set was removed, points at another
+	// host, or no longer holds the account
+	if set == nil || set.Connection != entry.Connection || set.Host != entry.Host || !set.hasAccount(entry.Username) {
+		return nil
+	}
+
+	account, err := getLibraryAccount(ctx, s, set.Name, entry.Username)
+	if err != nil {
+		return err
+	}
+
+	// Accounts that were being added to the set never stored a password
+	if account == nil || account.Password == "" {
+		return nil
+	}
+
+	client, err := b.getClient(ctx, s, set.Connection)
+	if err != nil {
+		return err
+	}
+	client = client.withHostKeyPolicy(set.HostKeyPolicy)
+
+	release, err := client.acquire(ctx, set.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	b.Logger().Debug("rolling back library account rotation", "set", set.Name, "username", account.Username, "host", set.Host)
+
+	return client.setPassword(ctx, set.Host, account.Username, account.Password)
+}
+
+// rollbackRootRotation sets the admin account on the connection's hosts
+// back to the stored password, in case a rotation set a new password
+// on some of them but never stored it.
+func (b *shellBackend) rollbackRootRotation(ctx context.Context, s logical.Storage, data interface{}) error {
+	var entry walRootRotation
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	b.rotationLock.Lock()
+	defer b.rotationLock.Unlock()
+
+	config, err := getConfig(ctx, s, entry.Connection)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to restore if the connection was removed, the new
+	// password was stored, or the connection was rewritten since
+	if config == nil || config.Username != entry.Username || !(passwordHistory{entry.PasswordHash}).contains(config.Password) {
+		return nil
+	}
+
+	b.Logger().Debug("rolling back root rotation", "connection", entry.Connection)
+
+	return b.restoreRootPassword(ctx, s, config, &entry, entry.Hosts)
+}
@@ -0,0 +1,218 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+func TestWALRollback_Account(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.UpdateOperation, hostRolePath+"web", map[string]interface{}{
+		"host": server.addr,
+	})
+
+	// The account is created, but the request fails before
+	// it is returned in a lease
+	created := false
+	server.handle(func(command, stdin string) (string, uint32) {
+		if strings.HasPrefix(command, "if id -u") && created {
+			return "exists\n", 0
+		}
+		if strings.Contains(command, "chpasswd") {
+			created = true
+			return "", 1
+		}
+		return "", 0
+	})
+
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath + "web",
+		Storage:   s,
+	}); err == nil {
+		t.Fatal("expected the request to fail")
+	}
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("expected a WAL entry for the account, got %d", len(ids))
+	}
+	entry, err := framework.GetWAL(context.Background(), s, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var account walAccount
+	if err := mapstructure.Decode(entry.Data, &account); err != nil {
+		t.Fatal(err)
+	}
+
+	testRollback(t, b, s)
+
+	deleted := server.commandsContaining("userdel -r " + account.Username)
+	if len(deleted) != 1 {
+		t.Fatalf("expected the account to be deleted, got %#v", server.commands())
+	}
+
+	ids, err = framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected the WAL entry to be removed, got %d", len(ids))
+	}
+}
+
+func TestWALRollback_KeepsEntryWhenHostFails(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	if _, err := framework.PutWAL(context.Background(), s, walTypeAccount, &walAccount{
+		Role:     "web",
+		Host:     server.addr,
+		Username: "v-web-abcdefgh",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.stop()
+	b.reset()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   s,
+		Data:      map[string]interface{}{"immediate": true},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected the rollback to fail")
+	}
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Errorf("expected the WAL entry to be kept for the next rollback, got %d", len(ids))
+	}
+}
+
+func TestWALRollback_SkipsIssuedAccount(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	// A failed request left an entry for a name that a later
+	// lease was issued under
+	if _, err := framework.PutWAL(context.Background(), s, walTypeAccount, &walAccount{
+		Role:       "web",
+		Connection: defaultConnectionName,
+		Host:       server.addr,
+		Username:   "v-web-abcdefgh",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setIssuedAccount(context.Background(), s, &issuedAccount{
+		Role:       "web",
+		Connection: defaultConnectionName,
+		Host:       server.addr,
+		Username:   "v-web-abcdefgh",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testRollback(t, b, s)
+
+	if n := len(server.commandsContaining("userdel")); n != 0 {
+		t.Fatalf("expected the lease's account to be kept, got %d userdel commands", n)
+	}
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected the WAL entry to be removed, got %d", len(ids))
+	}
+}
+
+func TestWALRollback_StaticRotation(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.CreateOperation, staticRolePath+"app", map[string]interface{}{
+		"host":     server.addr,
+		"username": "app",
+	})
+	password := testRequest(t, b, s, logical.ReadOperation, staticCredsPath+"app", nil).Data["password"].(string)
+
+	// A rotation that set a new password but never stored it
+	if _, err := framework.PutWAL(context.Background(), s, walTypeStaticRotation, &walAccount{
+		Role:       "app",
+		Connection: defaultConnectionName,
+		Host:       server.addr,
+		Username:   "app",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testRollback(t, b, s)
+
+	rotations := server.commandsContaining("chpasswd")
+	if len(rotations) != 2 || rotations[1].Stdin != "app:"+password+"\n" {
+		t.Fatalf("expected the stored password to be set again, got %#v", rotations)
+	}
+}
+
+func TestWALRollback_LibraryRotation(t *testing.T) {
+	b, s := getTestBackend(t)
+	server := newTestSSHServer(t)
+	testConfigure(t, b, s, server)
+
+	testRequest(t, b, s, logical.CreateOperation, libraryPath+"shared", map[string]interface{}{
+		"host":                  server.addr,
+		"service_account_names": "shared1",
+	})
+	account, err := getLibraryAccount(context.Background(), s, "shared", "shared1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil {
+		t.Fatal("expected the account to be stored")
+	}
+
+	// A rotation that set a new password but never stored it
+	if _, err := framework.PutWAL(context.Background(), s, walTypeLibraryRotation, &walAccount{
+		Role:       "shared",
+		Connection: defaultConnectionName,
+		Host:       server.addr,
+		Username:   "shared1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testRollback(t, b, s)
+
+	rotations := server.commandsContaining("chpasswd")
+	if len(rotations) != 2 || rotations[1].Stdin != "shared1:"+account.Password+"\n" {
+		t.Fatalf("expected the stored password to be set again, got %#v", rotations)
+	}
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected the WAL entry to be removed, got %d entries", len(ids))
+	}
+}